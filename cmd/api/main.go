@@ -1,18 +1,22 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/eduardohermesneto/rate-limiter/config"
 	"github.com/eduardohermesneto/rate-limiter/internal/domain"
 	"github.com/eduardohermesneto/rate-limiter/internal/infra/storage"
 	"github.com/eduardohermesneto/rate-limiter/internal/infra/web"
 	"github.com/eduardohermesneto/rate-limiter/internal/usecase"
+	"github.com/go-redis/redis/v8"
 )
 
 func main() {
@@ -22,32 +26,66 @@ func main() {
 	}
 
 	var store domain.Storage
-	redisStore, err := storage.NewRedisStorage(
-		cfg.RedisHost,
-		cfg.RedisPort,
-		cfg.RedisPassword,
-		cfg.RedisDB,
-	)
+	var policies domain.PolicyStore
+	redisStore, err := storage.NewRedisStorageFromClient(newRedisClient(cfg))
 	if err != nil {
 		log.Printf("Failed to connect to Redis: %v. Using memory storage", err)
-		store = storage.NewMemoryStorage()
+		store = newMemoryStorage(cfg)
+		policies = storage.NewMemoryPolicyStore()
 	} else {
-		store = redisStore
+		var primary domain.Storage = redisStore
+		if cfg.StorageTimeout > 0 {
+			primary = storage.NewTimeoutStorage(primary, cfg.StorageTimeout)
+		}
+		if cfg.CircuitThreshold > 0 {
+			breaker := storage.NewCircuitBreakerStorage(primary, cfg.CircuitThreshold, cfg.CircuitOpen)
+			breaker.SetHalfOpenProbes(cfg.CircuitProbes)
+			primary = breaker
+		}
+		store = primary
+		if cfg.StorageFailover {
+			store = storage.NewFailoverStorage(primary, newMemoryStorage(cfg), cfg.RedisProbe)
+		}
+		policies = storage.NewRedisPolicyStore(redisStore)
 	}
 	defer store.Close()
 
-	limiter := usecase.NewRateLimiter(
-		store,
-		cfg.RateLimitIP,
-		cfg.RateLimitToken,
-		cfg.BlockDuration,
-	)
+	limiter := newLimiter(store, cfg)
+	shared := &policySync{policies: policies}
+	shared.start(limiter)
+	defer shared.stop()
 
 	middleware := web.NewRateLimiterMiddleware(limiter)
+	middleware.SetRules(cfg.Rules)
+
+	localStore := newMemoryStorage(cfg)
+	defer localStore.Close()
+	middleware.SetFailureMode(cfg.OnFailure, cfg.FailureRetry, newLimiter(localStore, cfg))
+	if len(cfg.RouteCosts) > 0 {
+		middleware.SetCostFunc(web.CostByRoute(cfg.RouteCosts, 1))
+	}
+	if cfg.AdaptiveLatency > 0 {
+		middleware.SetAdaptive(web.NewAdaptiveLimit(cfg.AdaptiveLatency, cfg.AdaptiveErrors, cfg.AdaptiveFloor, cfg.AdaptiveCeiling))
+	}
+	if cfg.ShedCapacity > 0 {
+		classify := []web.PriorityFunc{
+			web.PriorityByToken(cfg.TokenPriorities),
+			web.PriorityByRoute(cfg.RoutePriorities),
+		}
+		if cfg.PriorityHeader != "" {
+			classify = append(classify, web.PriorityByHeader(cfg.PriorityHeader))
+		}
+		middleware.SetLoadShedder(web.NewLoadShedder(cfg.ShedCapacity, cfg.ShedShares, cfg.ShedRetryAfter), classify...)
+	}
+	if cfg.QueueSize > 0 {
+		queue := web.NewLeakyQueue(float64(cfg.QueueRate), cfg.QueueSize, cfg.QueueMaxWait)
+		defer queue.Close()
+		middleware.SetQueue(queue)
+	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", web.HealthHandler)
-	mux.HandleFunc("/test", web.TestHandler)
+	mux.Handle("/test", middleware.LimitConcurrency(http.HandlerFunc(web.TestHandler)))
 
 	handler := middleware.Handle(mux)
 
@@ -63,9 +101,191 @@ func main() {
 		}
 	}()
 
+	// Metrics expose process details, so they are only served on the
+	// admin port, which should not be reachable by clients.
+	if cfg.AdminPort != "" {
+		admin := http.NewServeMux()
+		admin.Handle("/debug/vars", expvar.Handler())
+
+		go func() {
+			log.Printf("Admin server starting on port %s", cfg.AdminPort)
+			if err := http.ListenAndServe(fmt.Sprintf(":%s", cfg.AdminPort), admin); err != nil {
+				log.Fatalf("Admin server failed to start: %v", err)
+			}
+		}()
+	}
+
+	reload := make(chan struct{}, 1)
+	done := make(chan struct{})
+	defer close(done)
+	if cfg.PolicyFile != "" {
+		go config.WatchFile(cfg.PolicyFile, policyWatchInterval, done, func() {
+			select {
+			case reload <- struct{}{}:
+			default:
+			}
+		})
+	}
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
-	<-stop
 
-	log.Println("Shutting down server...")
+	for {
+		select {
+		case <-hangup:
+			cfg = reloadConfig(cfg, store, shared, middleware)
+		case <-reload:
+			cfg = reloadConfig(cfg, store, shared, middleware)
+		case <-stop:
+			log.Println("Shutting down server...")
+			return
+		}
+	}
+}
+
+const policyWatchInterval = 2 * time.Second
+
+// restartRequired lists the settings applied only at startup.
+var restartRequired = map[string]bool{
+	"RedisHost":        true,
+	"RedisPort":        true,
+	"RedisPassword":    true,
+	"RedisDB":          true,
+	"RedisMode":        true,
+	"RedisAddrs":       true,
+	"RedisMasterName":  true,
+	"RedisProbe":       true,
+	"StorageFailover":  true,
+	"CircuitThreshold": true,
+	"CircuitOpen":      true,
+	"CircuitProbes":    true,
+	"StorageTimeout":   true,
+	"MemoryMaxEntries": true,
+	"ServerPort":       true,
+	"AdminPort":        true,
+	"QueueSize":        true,
+	"QueueRate":        true,
+	"QueueMaxWait":     true,
+	"AdaptiveLatency":  true,
+	"AdaptiveErrors":   true,
+	"AdaptiveFloor":    true,
+	"AdaptiveCeiling":  true,
+	"ShedCapacity":     true,
+	"ShedShares":       true,
+	"ShedRetryAfter":   true,
+	"PriorityHeader":   true,
+	"TokenPriorities":  true,
+	"RoutePriorities":  true,
+	"RouteCosts":       true,
+	"OnFailure":        true,
+	"FailureRetry":     true,
+}
+
+func newRedisClient(cfg *config.Config) redis.UniversalClient {
+	switch cfg.RedisMode {
+	case config.RedisModeSentinel:
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    cfg.RedisMasterName,
+			SentinelAddrs: cfg.RedisAddrs,
+			Password:      cfg.RedisPassword,
+			DB:            cfg.RedisDB,
+		})
+	case config.RedisModeCluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:    cfg.RedisAddrs,
+			Password: cfg.RedisPassword,
+		})
+	default:
+		return redis.NewClient(&redis.Options{
+			Addr:     fmt.Sprintf("%s:%s", cfg.RedisHost, cfg.RedisPort),
+			Password: cfg.RedisPassword,
+			DB:       cfg.RedisDB,
+		})
+	}
+}
+
+func newMemoryStorage(cfg *config.Config) *storage.MemoryStorage {
+	store := storage.NewMemoryStorage()
+	store.SetMaxEntries(cfg.MemoryMaxEntries)
+	return store
+}
+
+func newLimiter(store domain.Storage, cfg *config.Config) *usecase.RateLimiter {
+	limiter := usecase.NewRateLimiter(
+		store,
+		cfg.RateLimitIP,
+		cfg.RateLimitToken,
+		cfg.BlockDuration,
+	)
+	limiter.SetStrategy(domain.Strategy(cfg.Strategy), cfg.Burst)
+	for token, limit := range cfg.TokenLimits {
+		limiter.SetTokenLimit(token, limit)
+	}
+	limiter.SetWindows(cfg.IPWindow, cfg.TokenWindow)
+	limiter.SetWindowLimits(cfg.IPWindows, cfg.TokenWindows)
+	limiter.SetQuotas(cfg.IPQuotas, cfg.TokenQuotas)
+	limiter.SetGlobalLimit(cfg.GlobalLimit)
+	if len(cfg.BlockEscalation) > 0 {
+		limiter.SetBlockEscalation(cfg.BlockEscalation, cfg.OffenseMemory)
+	}
+	limiter.SetConcurrencyLimits(cfg.ConcurrencyIP, cfg.ConcurrencyToken, cfg.ConcurrencyLease)
+
+	return limiter
+}
+
+// policySync keeps the active limiter synced with the token policies shared
+// by every instance.
+type policySync struct {
+	policies domain.PolicyStore
+	cancel   context.CancelFunc
+}
+
+// start syncs limiter, stopping the sync of the previous one.
+func (s *policySync) start(limiter *usecase.RateLimiter) {
+	s.stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	if err := limiter.SyncPolicies(ctx, s.policies); err != nil {
+		log.Printf("Failed to sync shared token policies, retrying in the background: %v", err)
+	}
+}
+
+func (s *policySync) stop() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
+
+// reloadConfig loads the configuration again and swaps the limiter and
+// rules of middleware. An invalid configuration is rejected and current
+// stays active.
+func reloadConfig(current *config.Config, store domain.Storage, shared *policySync, middleware *web.RateLimiterMiddleware) *config.Config {
+	updated, err := config.Load()
+	if err != nil {
+		log.Printf("Rejected config reload, keeping the current config: %v", err)
+		return current
+	}
+
+	changes := config.Diff(current, updated)
+	if len(changes) == 0 {
+		log.Println("Config reloaded: no changes")
+		return current
+	}
+
+	for _, change := range changes {
+		if restartRequired[change.Field] {
+			log.Printf("Config changed, restart required: %s", change)
+		} else {
+			log.Printf("Config changed: %s", change)
+		}
+	}
+
+	limiter := newLimiter(store, updated)
+	shared.start(limiter)
+	middleware.Reload(limiter, updated.Rules)
+	return updated
 }
@@ -1,28 +1,93 @@
 package config
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
 	"github.com/joho/godotenv"
 )
 
+// Redis deployments selected by REDIS_MODE.
+const (
+	RedisModeSingle   = "single"
+	RedisModeSentinel = "sentinel"
+	RedisModeCluster  = "cluster"
+)
+
 type Config struct {
-	RateLimitIP    int
-	RateLimitToken int
-	BlockDuration  time.Duration
-	RedisHost      string
-	RedisPort      string
-	RedisPassword  string
-	RedisDB        int
-	ServerPort     string
-	TokenLimits    map[string]int
+	RateLimitIP      int
+	RateLimitToken   int
+	BlockDuration    time.Duration
+	BlockEscalation  []time.Duration
+	OffenseMemory    time.Duration
+	IPWindow         time.Duration
+	TokenWindow      time.Duration
+	Strategy         string
+	Burst            int
+	ConcurrencyIP    int
+	ConcurrencyToken int
+	ConcurrencyLease time.Duration
+	QueueSize        int
+	QueueRate        int
+	QueueMaxWait     time.Duration
+	AdaptiveLatency  time.Duration
+	AdaptiveErrors   float64
+	AdaptiveFloor    float64
+	AdaptiveCeiling  float64
+	ShedCapacity     int
+	ShedShares       map[domain.Priority]float64
+	ShedRetryAfter   time.Duration
+	OnFailure        domain.FailureMode
+	FailureRetry     time.Duration
+	PriorityHeader   string
+	TokenPriorities  map[string]domain.Priority
+	RoutePriorities  map[string]domain.Priority
+	RedisHost        string
+	RedisPort        string
+	RedisPassword    string
+	RedisDB          int
+	RedisMode        string
+	RedisAddrs       []string
+	RedisMasterName  string
+	RedisProbe       time.Duration
+	StorageFailover  bool
+	CircuitThreshold int
+	CircuitOpen      time.Duration
+	CircuitProbes    int
+	StorageTimeout   time.Duration
+	MemoryMaxEntries int
+	ServerPort       string
+	AdminPort        string
+	TokenLimits      map[string]int
+	RouteCosts       map[string]int
+	IPWindows        []domain.Limit
+	TokenWindows     []domain.Limit
+	IPQuotas         []domain.Quota
+	TokenQuotas      []domain.Quota
+	GlobalLimit      domain.Limit
+	PolicyFile       string
+	Rules            domain.RuleSet
 }
 
+// processEnv holds the variables set before any .env file was applied,
+// which always take precedence over the file.
+var processEnv = environKeys()
+
+// dotenvKeys holds the variables last applied from the .env file.
+var dotenvKeys = make(map[string]bool)
+
+// Load reads the configuration from the environment and the .env file. It
+// can be called again to reload both, e.g. on SIGHUP.
 func Load() (*Config, error) {
-	_ = godotenv.Load()
+	if err := loadDotenv(); err != nil {
+		return nil, err
+	}
 
 	rateLimitIP, err := getEnvAsInt("RATE_LIMIT_IP", 10)
 	if err != nil {
@@ -39,24 +104,374 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("invalid BLOCK_DURATION_SECONDS: %w", err)
 	}
 
+	blockEscalation, err := getEnvAsDurations("BLOCK_ESCALATION")
+	if err != nil {
+		return nil, fmt.Errorf("invalid BLOCK_ESCALATION: %w", err)
+	}
+
+	offenseMemory, err := getEnvAsDuration("BLOCK_OFFENSE_MEMORY", 24*time.Hour)
+	if err != nil {
+		return nil, fmt.Errorf("invalid BLOCK_OFFENSE_MEMORY: %w", err)
+	}
+
+	window, err := getEnvAsDuration("RATE_LIMIT_WINDOW", time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_WINDOW: %w", err)
+	}
+
+	ipWindow, err := getEnvAsDuration("RATE_LIMIT_IP_WINDOW", window)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_IP_WINDOW: %w", err)
+	}
+
+	tokenWindow, err := getEnvAsDuration("RATE_LIMIT_TOKEN_WINDOW", window)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_TOKEN_WINDOW: %w", err)
+	}
+
+	strategy := getEnv("RATE_LIMIT_STRATEGY", "fixed_window")
+	if !validStrategy(strategy) {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_STRATEGY: %q", strategy)
+	}
+
+	burst, err := getEnvAsInt("RATE_LIMIT_BURST", 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_BURST: %w", err)
+	}
+
+	routeCosts, err := getEnvAsCosts("ROUTE_COSTS")
+	if err != nil {
+		return nil, fmt.Errorf("invalid ROUTE_COSTS: %w", err)
+	}
+
+	ipWindows, err := getEnvAsLimits("RATE_LIMIT_IP_WINDOWS")
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_IP_WINDOWS: %w", err)
+	}
+
+	tokenWindows, err := getEnvAsLimits("RATE_LIMIT_TOKEN_WINDOWS")
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_TOKEN_WINDOWS: %w", err)
+	}
+
+	if (len(ipWindows) > 1 || len(tokenWindows) > 1) && strategy != "fixed_window" {
+		return nil, fmt.Errorf("multiple windows require the fixed_window strategy")
+	}
+
+	globalLimits, err := getEnvAsLimits("RATE_LIMIT_GLOBAL")
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_GLOBAL: %w", err)
+	}
+	if len(globalLimits) > 1 {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_GLOBAL: expected a single <limit>/<window>")
+	}
+
+	var globalLimit domain.Limit
+	if len(globalLimits) == 1 {
+		globalLimit = globalLimits[0]
+	}
+
+	timezone, err := time.LoadLocation(getEnv("RATE_LIMIT_TIMEZONE", "UTC"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_TIMEZONE: %w", err)
+	}
+
+	ipQuotas, err := getEnvAsQuotas("RATE_LIMIT_IP_QUOTAS", timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_IP_QUOTAS: %w", err)
+	}
+
+	tokenQuotas, err := getEnvAsQuotas("RATE_LIMIT_TOKEN_QUOTAS", timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_TOKEN_QUOTAS: %w", err)
+	}
+
+	if (len(ipQuotas) > 0 || len(tokenQuotas) > 0) && strategy != "fixed_window" {
+		return nil, fmt.Errorf("quotas require the fixed_window strategy")
+	}
+
+	concurrencyIP, err := getEnvAsInt("CONCURRENCY_LIMIT_IP", 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CONCURRENCY_LIMIT_IP: %w", err)
+	}
+
+	concurrencyToken, err := getEnvAsInt("CONCURRENCY_LIMIT_TOKEN", 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CONCURRENCY_LIMIT_TOKEN: %w", err)
+	}
+
+	concurrencyLeaseSecs, err := getEnvAsInt("CONCURRENCY_LEASE_SECONDS", 30)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CONCURRENCY_LEASE_SECONDS: %w", err)
+	}
+
+	queueSize, err := getEnvAsInt("QUEUE_SIZE", 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid QUEUE_SIZE: %w", err)
+	}
+
+	queueRate, err := getEnvAsInt("QUEUE_RATE", rateLimitIP)
+	if err != nil {
+		return nil, fmt.Errorf("invalid QUEUE_RATE: %w", err)
+	}
+	if queueSize > 0 && queueRate <= 0 {
+		return nil, fmt.Errorf("invalid QUEUE_RATE: must be positive when QUEUE_SIZE is set")
+	}
+
+	queueMaxWaitMs, err := getEnvAsInt("QUEUE_MAX_WAIT_MS", 1000)
+	if err != nil {
+		return nil, fmt.Errorf("invalid QUEUE_MAX_WAIT_MS: %w", err)
+	}
+
+	adaptiveLatencyMs, err := getEnvAsInt("ADAPTIVE_TARGET_LATENCY_MS", 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ADAPTIVE_TARGET_LATENCY_MS: %w", err)
+	}
+
+	adaptiveErrors, err := getEnvAsFloat("ADAPTIVE_MAX_ERROR_RATE", 0.05)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ADAPTIVE_MAX_ERROR_RATE: %w", err)
+	}
+
+	adaptiveFloor, err := getEnvAsFloat("ADAPTIVE_FLOOR", 0.1)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ADAPTIVE_FLOOR: %w", err)
+	}
+
+	adaptiveCeiling, err := getEnvAsFloat("ADAPTIVE_CEILING", 1)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ADAPTIVE_CEILING: %w", err)
+	}
+	if adaptiveFloor <= 0 || adaptiveFloor > adaptiveCeiling {
+		return nil, fmt.Errorf("invalid ADAPTIVE_FLOOR: must be positive and at most ADAPTIVE_CEILING")
+	}
+
+	shedCapacity, err := getEnvAsInt("SHED_CAPACITY", 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SHED_CAPACITY: %w", err)
+	}
+
+	shedShares, err := getEnvAsShares("SHED_SHARES", "low=0.5,normal=0.8")
+	if err != nil {
+		return nil, fmt.Errorf("invalid SHED_SHARES: %w", err)
+	}
+
+	shedRetryAfterSecs, err := getEnvAsInt("SHED_RETRY_AFTER_SECONDS", 1)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SHED_RETRY_AFTER_SECONDS: %w", err)
+	}
+
+	tokenPriorities, err := getEnvAsPriorities("TOKEN_PRIORITIES")
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOKEN_PRIORITIES: %w", err)
+	}
+
+	routePriorities, err := getEnvAsPriorities("ROUTE_PRIORITIES")
+	if err != nil {
+		return nil, fmt.Errorf("invalid ROUTE_PRIORITIES: %w", err)
+	}
+
+	tokenLimits, err := loadTokenLimits()
+	if err != nil {
+		return nil, err
+	}
+
+	onFailure, err := domain.ParseFailureMode(getEnv("RATE_LIMIT_ON_FAILURE", string(domain.FailureModeError)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_ON_FAILURE: %w", err)
+	}
+
+	failureRetrySecs, err := getEnvAsInt("FAILURE_RETRY_AFTER_SECONDS", 5)
+	if err != nil {
+		return nil, fmt.Errorf("invalid FAILURE_RETRY_AFTER_SECONDS: %w", err)
+	}
+
+	policyFile := os.Getenv("POLICY_FILE")
+
+	var rules domain.RuleSet
+	if policyFile != "" {
+		rules, err = LoadPolicyFile(policyFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid POLICY_FILE: %w", err)
+		}
+	}
+
+	serverPort := getEnv("SERVER_PORT", "8080")
+	adminPort := os.Getenv("ADMIN_PORT")
+	if adminPort != "" && adminPort == serverPort {
+		return nil, fmt.Errorf("invalid ADMIN_PORT: must differ from SERVER_PORT")
+	}
+
 	redisDB, err := getEnvAsInt("REDIS_DB", 0)
 	if err != nil {
 		return nil, fmt.Errorf("invalid REDIS_DB: %w", err)
 	}
 
+	redisProbe, err := getEnvAsDuration("REDIS_PROBE_INTERVAL", 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_PROBE_INTERVAL: %w", err)
+	}
+
+	storageFailover, err := getEnvAsBool("STORAGE_FAILOVER", false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid STORAGE_FAILOVER: %w", err)
+	}
+
+	circuitThreshold, err := getEnvAsInt("CIRCUIT_FAILURE_THRESHOLD", 5)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIRCUIT_FAILURE_THRESHOLD: %w", err)
+	}
+	if circuitThreshold < 0 {
+		return nil, fmt.Errorf("invalid CIRCUIT_FAILURE_THRESHOLD: must not be negative")
+	}
+
+	circuitOpen, err := getEnvAsDuration("CIRCUIT_OPEN_DURATION", 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIRCUIT_OPEN_DURATION: %w", err)
+	}
+
+	circuitProbes, err := getEnvAsInt("CIRCUIT_HALF_OPEN_PROBES", 1)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIRCUIT_HALF_OPEN_PROBES: %w", err)
+	}
+	if circuitProbes <= 0 {
+		return nil, fmt.Errorf("invalid CIRCUIT_HALF_OPEN_PROBES: must be positive")
+	}
+
+	storageTimeout, err := getEnvAsOptionalDuration("STORAGE_TIMEOUT", time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("invalid STORAGE_TIMEOUT: %w", err)
+	}
+
+	memoryMaxEntries, err := getEnvAsInt("MEMORY_MAX_ENTRIES", 1000000)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MEMORY_MAX_ENTRIES: %w", err)
+	}
+	if memoryMaxEntries < 0 {
+		return nil, fmt.Errorf("invalid MEMORY_MAX_ENTRIES: must not be negative")
+	}
+
+	redisMode := getEnv("REDIS_MODE", RedisModeSingle)
+	redisAddrs := getEnvAsList("REDIS_ADDRS")
+	redisMasterName := os.Getenv("REDIS_MASTER_NAME")
+	switch redisMode {
+	case RedisModeSingle:
+	case RedisModeSentinel:
+		if redisMasterName == "" || len(redisAddrs) == 0 {
+			return nil, fmt.Errorf("REDIS_MODE sentinel requires REDIS_MASTER_NAME and REDIS_ADDRS")
+		}
+	case RedisModeCluster:
+		if len(redisAddrs) == 0 {
+			return nil, fmt.Errorf("REDIS_MODE cluster requires REDIS_ADDRS")
+		}
+		if redisDB != 0 {
+			return nil, fmt.Errorf("REDIS_DB must be 0 in REDIS_MODE cluster")
+		}
+	default:
+		return nil, fmt.Errorf("invalid REDIS_MODE: %q", redisMode)
+	}
+
 	return &Config{
-		RateLimitIP:    rateLimitIP,
-		RateLimitToken: rateLimitToken,
-		BlockDuration:  time.Duration(blockDurationSecs) * time.Second,
-		RedisHost:      getEnv("REDIS_HOST", "localhost"),
-		RedisPort:      getEnv("REDIS_PORT", "6379"),
-		RedisPassword:  getEnv("REDIS_PASSWORD", ""),
-		RedisDB:        redisDB,
-		ServerPort:     getEnv("SERVER_PORT", "8080"),
-		TokenLimits:    make(map[string]int),
+		RateLimitIP:      rateLimitIP,
+		RateLimitToken:   rateLimitToken,
+		BlockDuration:    time.Duration(blockDurationSecs) * time.Second,
+		BlockEscalation:  blockEscalation,
+		OffenseMemory:    offenseMemory,
+		IPWindow:         ipWindow,
+		TokenWindow:      tokenWindow,
+		Strategy:         strategy,
+		Burst:            burst,
+		ConcurrencyIP:    concurrencyIP,
+		ConcurrencyToken: concurrencyToken,
+		ConcurrencyLease: time.Duration(concurrencyLeaseSecs) * time.Second,
+		QueueSize:        queueSize,
+		QueueRate:        queueRate,
+		QueueMaxWait:     time.Duration(queueMaxWaitMs) * time.Millisecond,
+		AdaptiveLatency:  time.Duration(adaptiveLatencyMs) * time.Millisecond,
+		AdaptiveErrors:   adaptiveErrors,
+		AdaptiveFloor:    adaptiveFloor,
+		AdaptiveCeiling:  adaptiveCeiling,
+		ShedCapacity:     shedCapacity,
+		ShedShares:       shedShares,
+		ShedRetryAfter:   time.Duration(shedRetryAfterSecs) * time.Second,
+		OnFailure:        onFailure,
+		FailureRetry:     time.Duration(failureRetrySecs) * time.Second,
+		PriorityHeader:   getEnv("PRIORITY_HEADER", ""),
+		TokenPriorities:  tokenPriorities,
+		RoutePriorities:  routePriorities,
+		RedisHost:        getEnv("REDIS_HOST", "localhost"),
+		RedisPort:        getEnv("REDIS_PORT", "6379"),
+		RedisPassword:    getEnv("REDIS_PASSWORD", ""),
+		RedisDB:          redisDB,
+		RedisMode:        redisMode,
+		RedisAddrs:       redisAddrs,
+		RedisMasterName:  redisMasterName,
+		RedisProbe:       redisProbe,
+		StorageFailover:  storageFailover,
+		CircuitThreshold: circuitThreshold,
+		CircuitOpen:      circuitOpen,
+		CircuitProbes:    circuitProbes,
+		StorageTimeout:   storageTimeout,
+		MemoryMaxEntries: memoryMaxEntries,
+		ServerPort:       serverPort,
+		AdminPort:        adminPort,
+		TokenLimits:      tokenLimits,
+		RouteCosts:       routeCosts,
+		IPWindows:        ipWindows,
+		TokenWindows:     tokenWindows,
+		IPQuotas:         ipQuotas,
+		TokenQuotas:      tokenQuotas,
+		GlobalLimit:      globalLimit,
+		PolicyFile:       policyFile,
+		Rules:            rules,
 	}, nil
 }
 
+// loadDotenv applies the .env file without overriding the process
+// environment, and unsets the variables removed from the file since the
+// last call.
+func loadDotenv() error {
+	values, err := godotenv.Read()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read .env: %w", err)
+	}
+
+	for key := range dotenvKeys {
+		if _, exists := values[key]; !exists {
+			os.Unsetenv(key)
+			delete(dotenvKeys, key)
+		}
+	}
+
+	for key, value := range values {
+		if processEnv[key] {
+			continue
+		}
+		os.Setenv(key, value)
+		dotenvKeys[key] = true
+	}
+
+	return nil
+}
+
+func environKeys() map[string]bool {
+	keys := make(map[string]bool)
+	for _, env := range os.Environ() {
+		key, _, _ := strings.Cut(env, "=")
+		keys[key] = true
+	}
+	return keys
+}
+
+func validStrategy(strategy string) bool {
+	switch domain.Strategy(strategy) {
+	case domain.StrategyFixedWindow, domain.StrategyTokenBucket, domain.StrategySlidingLog, domain.StrategySlidingWindow, domain.StrategyGCRA:
+		return true
+	default:
+		return false
+	}
+}
+
 func getEnv(key, defaultValue string) string {
 	value := os.Getenv(key)
 	if value == "" {
@@ -72,3 +487,297 @@ func getEnvAsInt(key string, defaultValue int) (int, error) {
 	}
 	return strconv.Atoi(valueStr)
 }
+
+func getEnvAsBool(key string, defaultValue bool) (bool, error) {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue, nil
+	}
+	return strconv.ParseBool(valueStr)
+}
+
+func getEnvAsFloat(key string, defaultValue float64) (float64, error) {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue, nil
+	}
+	return strconv.ParseFloat(valueStr, 64)
+}
+
+func getEnvAsDuration(key string, defaultValue time.Duration) (time.Duration, error) {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue, nil
+	}
+
+	value, err := time.ParseDuration(valueStr)
+	if err != nil {
+		return 0, err
+	}
+	if value <= 0 {
+		return 0, fmt.Errorf("duration must be positive, got %q", valueStr)
+	}
+	return value, nil
+}
+
+// getEnvAsOptionalDuration is like getEnvAsDuration but accepts 0, for
+// settings where it disables the feature.
+func getEnvAsOptionalDuration(key string, defaultValue time.Duration) (time.Duration, error) {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue, nil
+	}
+
+	value, err := time.ParseDuration(valueStr)
+	if err != nil {
+		return 0, err
+	}
+	if value < 0 {
+		return 0, fmt.Errorf("duration must not be negative, got %q", valueStr)
+	}
+	return value, nil
+}
+
+// getEnvAsList parses a comma separated list, e.g. "host1:6379,host2:6379".
+func getEnvAsList(key string) []string {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return nil
+	}
+
+	var values []string
+	for _, part := range strings.Split(valueStr, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			values = append(values, part)
+		}
+	}
+	return values
+}
+
+// getEnvAsDurations parses a comma separated list of durations, e.g.
+// "1m,5m,30m,24h".
+func getEnvAsDurations(key string) ([]time.Duration, error) {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return nil, nil
+	}
+
+	var durations []time.Duration
+	for _, part := range strings.Split(valueStr, ",") {
+		duration, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		if duration <= 0 {
+			return nil, fmt.Errorf("duration must be positive, got %q", part)
+		}
+		durations = append(durations, duration)
+	}
+
+	return durations, nil
+}
+
+// getEnvAsLimits parses a comma separated list of limit/window pairs,
+// e.g. "10/1s,300/1m,10000/24h".
+func getEnvAsLimits(key string) ([]domain.Limit, error) {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return nil, nil
+	}
+	return parseLimits(strings.Split(valueStr, ","))
+}
+
+func parseLimits(parts []string) ([]domain.Limit, error) {
+	var limits []domain.Limit
+	for _, part := range parts {
+		maxStr, windowStr, found := strings.Cut(strings.TrimSpace(part), "/")
+		if !found {
+			return nil, fmt.Errorf("expected <limit>/<window>, got %q", part)
+		}
+
+		maxRequests, err := strconv.Atoi(maxStr)
+		if err != nil {
+			return nil, err
+		}
+
+		window, err := time.ParseDuration(windowStr)
+		if err != nil {
+			return nil, err
+		}
+		if window <= 0 {
+			return nil, fmt.Errorf("window must be positive, got %q", windowStr)
+		}
+
+		limits = append(limits, domain.Limit{MaxRequests: maxRequests, Window: window})
+	}
+
+	return limits, nil
+}
+
+// getEnvAsQuotas parses a comma separated list of limit/period pairs,
+// e.g. "1000/day,100000/month", resetting on period boundaries in location.
+func getEnvAsQuotas(key string, location *time.Location) ([]domain.Quota, error) {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return nil, nil
+	}
+
+	var quotas []domain.Quota
+	for _, part := range strings.Split(valueStr, ",") {
+		maxStr, periodStr, found := strings.Cut(strings.TrimSpace(part), "/")
+		if !found {
+			return nil, fmt.Errorf("expected <limit>/<period>, got %q", part)
+		}
+
+		maxRequests, err := strconv.Atoi(maxStr)
+		if err != nil {
+			return nil, err
+		}
+
+		period := domain.Period(periodStr)
+		switch period {
+		case domain.PeriodHour, domain.PeriodDay, domain.PeriodWeek, domain.PeriodMonth:
+		default:
+			return nil, fmt.Errorf("period must be hour, day, week or month, got %q", periodStr)
+		}
+
+		quotas = append(quotas, domain.Quota{MaxRequests: maxRequests, Period: period, Location: location})
+	}
+
+	return quotas, nil
+}
+
+// loadTokenLimits merges the per-token limits of the JSON file at
+// TOKEN_LIMITS_FILE, the JSON object in TOKEN_LIMITS and the TOKEN_LIMIT_<token>
+// variables, in increasing order of precedence.
+func loadTokenLimits() (map[string]int, error) {
+	limits := make(map[string]int)
+
+	if path := os.Getenv("TOKEN_LIMITS_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TOKEN_LIMITS_FILE: %w", err)
+		}
+		if err := json.Unmarshal(data, &limits); err != nil {
+			return nil, fmt.Errorf("invalid TOKEN_LIMITS_FILE: %w", err)
+		}
+	}
+
+	if valueStr := os.Getenv("TOKEN_LIMITS"); valueStr != "" {
+		if err := json.Unmarshal([]byte(valueStr), &limits); err != nil {
+			return nil, fmt.Errorf("invalid TOKEN_LIMITS: %w", err)
+		}
+	}
+
+	for _, env := range os.Environ() {
+		name, valueStr, _ := strings.Cut(env, "=")
+		token, found := strings.CutPrefix(name, "TOKEN_LIMIT_")
+		if !found {
+			continue
+		}
+
+		limit, err := strconv.Atoi(valueStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		limits[token] = limit
+	}
+
+	for token, limit := range limits {
+		if token == "" {
+			return nil, fmt.Errorf("invalid token limits: empty token")
+		}
+		if limit <= 0 {
+			return nil, fmt.Errorf("invalid token limit for %q: must be positive, got %d", token, limit)
+		}
+	}
+
+	return limits, nil
+}
+
+// getEnvAsCosts parses a comma separated list of route costs, where a route
+// is either "METHOD /path" or "/path", e.g. "POST /export=50,/ping=1".
+func getEnvAsCosts(key string) (map[string]int, error) {
+	costs := make(map[string]int)
+
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return costs, nil
+	}
+
+	for _, part := range strings.Split(valueStr, ",") {
+		route, costStr, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			return nil, fmt.Errorf("expected <route>=<cost>, got %q", part)
+		}
+
+		cost, err := strconv.Atoi(costStr)
+		if err != nil {
+			return nil, err
+		}
+		if cost <= 0 {
+			return nil, fmt.Errorf("cost must be positive, got %q", costStr)
+		}
+
+		costs[route] = cost
+	}
+
+	return costs, nil
+}
+
+// getEnvAsShares parses the share of the load shedder capacity each
+// priority may use, e.g. "low=0.5,normal=0.8".
+func getEnvAsShares(key, defaultValue string) (map[domain.Priority]float64, error) {
+	shares := make(map[domain.Priority]float64)
+
+	for _, part := range strings.Split(getEnv(key, defaultValue), ",") {
+		priorityStr, shareStr, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			return nil, fmt.Errorf("expected <priority>=<share>, got %q", part)
+		}
+
+		priority, err := domain.ParsePriority(priorityStr)
+		if err != nil {
+			return nil, err
+		}
+
+		share, err := strconv.ParseFloat(shareStr, 64)
+		if err != nil {
+			return nil, err
+		}
+		if share < 0 || share > 1 {
+			return nil, fmt.Errorf("share must be between 0 and 1, got %q", shareStr)
+		}
+
+		shares[priority] = share
+	}
+
+	return shares, nil
+}
+
+// getEnvAsPriorities parses a comma separated list of priorities by token
+// or route, e.g. "POST /export=low,/orders=critical".
+func getEnvAsPriorities(key string) (map[string]domain.Priority, error) {
+	priorities := make(map[string]domain.Priority)
+
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return priorities, nil
+	}
+
+	for _, part := range strings.Split(valueStr, ",") {
+		name, priorityStr, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			return nil, fmt.Errorf("expected <name>=<priority>, got %q", part)
+		}
+
+		priority, err := domain.ParsePriority(priorityStr)
+		if err != nil {
+			return nil, err
+		}
+
+		priorities[name] = priority
+	}
+
+	return priorities, nil
+}
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadTokenLimits_Precedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limits.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"file-only": 10, "file-and-json": 20, "all": 30}`), 0o600))
+
+	t.Setenv("TOKEN_LIMITS_FILE", path)
+	t.Setenv("TOKEN_LIMITS", `{"file-and-json": 200, "all": 300, "json-only": 400}`)
+	t.Setenv("TOKEN_LIMIT_all", "3000")
+	t.Setenv("TOKEN_LIMIT_env-only", "5000")
+
+	limits, err := loadTokenLimits()
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]int{
+		"file-only":     10,
+		"file-and-json": 200,
+		"all":           3000,
+		"json-only":     400,
+		"env-only":      5000,
+	}, limits)
+}
+
+func TestLoadTokenLimits_Empty(t *testing.T) {
+	t.Setenv("TOKEN_LIMITS_FILE", "")
+	t.Setenv("TOKEN_LIMITS", "")
+
+	limits, err := loadTokenLimits()
+	require.NoError(t, err)
+	assert.Empty(t, limits)
+}
+
+func TestLoadTokenLimits_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		err  string
+	}{
+		{"missing file", map[string]string{"TOKEN_LIMITS_FILE": "/nonexistent/limits.json"}, "invalid TOKEN_LIMITS_FILE"},
+		{"malformed json", map[string]string{"TOKEN_LIMITS": `{"a": `}, "invalid TOKEN_LIMITS"},
+		{"non numeric json limit", map[string]string{"TOKEN_LIMITS": `{"a": "ten"}`}, "invalid TOKEN_LIMITS"},
+		{"non numeric variable", map[string]string{"TOKEN_LIMIT_a": "ten"}, "invalid TOKEN_LIMIT_a"},
+		{"empty token", map[string]string{"TOKEN_LIMITS": `{"": 10}`}, "empty token"},
+		{"zero limit", map[string]string{"TOKEN_LIMITS": `{"a": 0}`}, "must be positive"},
+		{"negative variable", map[string]string{"TOKEN_LIMIT_a": "-5"}, "must be positive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TOKEN_LIMITS_FILE", "")
+			t.Setenv("TOKEN_LIMITS", "")
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			_, err := loadTokenLimits()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
+
+func TestLoadTokenLimits_FileInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limits.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[10, 20]`), 0o600))
+
+	t.Setenv("TOKEN_LIMITS_FILE", path)
+	t.Setenv("TOKEN_LIMITS", "")
+
+	_, err := loadTokenLimits()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid TOKEN_LIMITS_FILE")
+}
+
+func TestLoad_StorageTimeout(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected time.Duration
+		err      bool
+	}{
+		{"", time.Second, false},
+		{"200ms", 200 * time.Millisecond, false},
+		{"0", 0, false},
+		{"0s", 0, false},
+		{"-1s", 0, true},
+		{"soon", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("STORAGE_TIMEOUT", tt.value)
+
+			cfg, err := Load()
+			if tt.err {
+				assert.ErrorContains(t, err, "invalid STORAGE_TIMEOUT")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, cfg.StorageTimeout)
+		})
+	}
+}
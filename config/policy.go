@@ -0,0 +1,194 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
+	"gopkg.in/yaml.v3"
+)
+
+var ruleNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// policyFile is the schema of POLICY_FILE. JSON files use the same keys,
+// as JSON is parsed as YAML.
+type policyFile struct {
+	Mode  string       `yaml:"mode"`
+	Rules []policyRule `yaml:"rules"`
+}
+
+type policyRule struct {
+	Name  string `yaml:"name"`
+	Match struct {
+		PathPrefix string   `yaml:"path_prefix"`
+		PathRegex  string   `yaml:"path_regex"`
+		Methods    []string `yaml:"methods"`
+		Headers    []string `yaml:"headers"`
+		Identity   string   `yaml:"identity"`
+	} `yaml:"match"`
+	Strategy      string   `yaml:"strategy"`
+	Limit         int      `yaml:"limit"`
+	Window        string   `yaml:"window"`
+	Windows       []string `yaml:"windows"`
+	Burst         int      `yaml:"burst"`
+	BlockDuration string   `yaml:"block_duration"`
+	OnFailure     string   `yaml:"on_failure"`
+}
+
+// LoadPolicyFile reads and validates a YAML or JSON policy file, e.g.
+//
+//	mode: first
+//	rules:
+//	  - name: export
+//	    match: {path_prefix: /export, methods: [POST], identity: token}
+//	    strategy: token_bucket
+//	    limit: 10
+//	    window: 1m
+//	    block_duration: 5m
+//	    on_failure: local
+func LoadPolicyFile(path string) (domain.RuleSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return domain.RuleSet{}, err
+	}
+	return parsePolicy(data)
+}
+
+func parsePolicy(data []byte) (domain.RuleSet, error) {
+	var file policyFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return domain.RuleSet{}, fmt.Errorf("failed to parse policy: %w", err)
+	}
+
+	set := domain.RuleSet{Mode: domain.RuleMode(file.Mode)}
+	switch set.Mode {
+	case "":
+		set.Mode = domain.RuleModeFirst
+	case domain.RuleModeFirst, domain.RuleModeAll:
+	default:
+		return domain.RuleSet{}, fmt.Errorf("mode must be first or all, got %q", file.Mode)
+	}
+
+	names := make(map[string]bool)
+	unrefundable := 0
+	for i, rule := range file.Rules {
+		parsed, err := parseRule(rule)
+		if err != nil {
+			return domain.RuleSet{}, fmt.Errorf("rule %d (%s): %w", i+1, rule.Name, err)
+		}
+
+		if names[parsed.Name] {
+			return domain.RuleSet{}, fmt.Errorf("rule %d: duplicate name %q", i+1, parsed.Name)
+		}
+		names[parsed.Name] = true
+
+		if parsed.Strategy != domain.StrategyFixedWindow {
+			unrefundable++
+		}
+
+		set.Rules = append(set.Rules, parsed)
+	}
+
+	if set.Mode == domain.RuleModeAll && unrefundable > 1 {
+		return domain.RuleSet{}, fmt.Errorf("mode all allows at most one rule with a strategy other than %q", domain.StrategyFixedWindow)
+	}
+
+	return set, nil
+}
+
+func parseRule(rule policyRule) (domain.Rule, error) {
+	if !ruleNamePattern.MatchString(rule.Name) {
+		return domain.Rule{}, fmt.Errorf("name must be made of letters, digits, '-' and '_', got %q", rule.Name)
+	}
+
+	parsed := domain.Rule{
+		Name:        rule.Name,
+		PathPrefix:  rule.Match.PathPrefix,
+		Headers:     rule.Match.Headers,
+		Identity:    domain.RateLimitType(rule.Match.Identity),
+		Strategy:    domain.Strategy(rule.Strategy),
+		MaxRequests: rule.Limit,
+		Burst:       rule.Burst,
+	}
+
+	if rule.Match.PathRegex != "" {
+		pathRegex, err := regexp.Compile(rule.Match.PathRegex)
+		if err != nil {
+			return domain.Rule{}, fmt.Errorf("invalid path_regex: %w", err)
+		}
+		parsed.PathRegex = pathRegex
+	}
+
+	for _, method := range rule.Match.Methods {
+		method = strings.ToUpper(method)
+		switch method {
+		case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+			http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		default:
+			return domain.Rule{}, fmt.Errorf("unknown method %q", method)
+		}
+		parsed.Methods = append(parsed.Methods, method)
+	}
+
+	switch parsed.Identity {
+	case "", domain.RateLimitTypeIP, domain.RateLimitTypeToken:
+	default:
+		return domain.Rule{}, fmt.Errorf("identity must be ip or token, got %q", rule.Match.Identity)
+	}
+
+	if parsed.Strategy == "" {
+		parsed.Strategy = domain.StrategyFixedWindow
+	}
+	if !validStrategy(string(parsed.Strategy)) {
+		return domain.Rule{}, fmt.Errorf("invalid strategy %q", rule.Strategy)
+	}
+
+	if rule.Window != "" {
+		window, err := time.ParseDuration(rule.Window)
+		if err != nil {
+			return domain.Rule{}, fmt.Errorf("invalid window: %w", err)
+		}
+		if window <= 0 {
+			return domain.Rule{}, fmt.Errorf("window must be positive, got %q", rule.Window)
+		}
+		parsed.Window = window
+	}
+
+	if len(rule.Windows) > 0 {
+		limits, err := parseLimits(rule.Windows)
+		if err != nil {
+			return domain.Rule{}, fmt.Errorf("invalid windows: %w", err)
+		}
+		if parsed.Strategy != domain.StrategyFixedWindow {
+			return domain.Rule{}, fmt.Errorf("windows require the fixed_window strategy, use limit and window instead")
+		}
+		parsed.Limits = limits
+	} else if rule.Limit <= 0 {
+		return domain.Rule{}, fmt.Errorf("limit must be positive, got %d", rule.Limit)
+	}
+
+	if rule.BlockDuration != "" {
+		blockDuration, err := time.ParseDuration(rule.BlockDuration)
+		if err != nil {
+			return domain.Rule{}, fmt.Errorf("invalid block_duration: %w", err)
+		}
+		if blockDuration < 0 {
+			return domain.Rule{}, fmt.Errorf("block_duration must not be negative, got %q", rule.BlockDuration)
+		}
+		parsed.BlockDuration = blockDuration
+	}
+
+	if rule.OnFailure != "" {
+		onFailure, err := domain.ParseFailureMode(rule.OnFailure)
+		if err != nil {
+			return domain.Rule{}, err
+		}
+		parsed.OnFailure = onFailure
+	}
+
+	return parsed, nil
+}
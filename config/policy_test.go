@@ -0,0 +1,121 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePolicy(t *testing.T) {
+	set, err := parsePolicy([]byte(`
+mode: all
+rules:
+  - name: export
+    match: {path_prefix: /export, path_regex: '^/export/[0-9]+$', methods: [post, GET], headers: [X-Tenant], identity: token}
+    strategy: token_bucket
+    limit: 10
+    window: 1m
+    burst: 20
+    block_duration: 5m
+    on_failure: local
+  - name: search
+    windows: [10/1s, 100/1m]
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, domain.RuleModeAll, set.Mode)
+	require.Len(t, set.Rules, 2)
+
+	export := set.Rules[0]
+	assert.Equal(t, "export", export.Name)
+	assert.Equal(t, "/export", export.PathPrefix)
+	assert.True(t, export.PathRegex.MatchString("/export/42"))
+	assert.Equal(t, []string{"POST", "GET"}, export.Methods)
+	assert.Equal(t, []string{"X-Tenant"}, export.Headers)
+	assert.Equal(t, domain.RateLimitTypeToken, export.Identity)
+	assert.Equal(t, domain.StrategyTokenBucket, export.Strategy)
+	assert.Equal(t, 10, export.MaxRequests)
+	assert.Equal(t, time.Minute, export.Window)
+	assert.Equal(t, 20, export.Burst)
+	assert.Equal(t, 5*time.Minute, export.BlockDuration)
+	assert.Equal(t, domain.FailureModeLocal, export.OnFailure)
+
+	search := set.Rules[1]
+	assert.Equal(t, domain.StrategyFixedWindow, search.Strategy)
+	assert.Equal(t, []domain.Limit{
+		{MaxRequests: 10, Window: time.Second},
+		{MaxRequests: 100, Window: time.Minute},
+	}, search.Limits)
+	assert.Empty(t, search.OnFailure)
+}
+
+func TestParsePolicy_DefaultMode(t *testing.T) {
+	set, err := parsePolicy([]byte(`rules: [{name: api, limit: 5}]`))
+	require.NoError(t, err)
+
+	assert.Equal(t, domain.RuleModeFirst, set.Mode)
+}
+
+func TestParsePolicy_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		err    string
+	}{
+		{"malformed", `rules: {`, "failed to parse policy"},
+		{"unknown mode", `mode: any`, "mode must be first or all"},
+		{"missing name", `rules: [{limit: 5}]`, "name must be made of"},
+		{"invalid name", `rules: [{name: "a b", limit: 5}]`, "name must be made of"},
+		{"duplicate name", `rules: [{name: api, limit: 5}, {name: api, limit: 10}]`, `rule 2: duplicate name "api"`},
+		{"bad regex", `rules: [{name: api, limit: 5, match: {path_regex: "(["}}]`, "invalid path_regex"},
+		{"bad method", `rules: [{name: api, limit: 5, match: {methods: [FETCH]}}]`, `unknown method "FETCH"`},
+		{"bad identity", `rules: [{name: api, limit: 5, match: {identity: org}}]`, "identity must be ip or token"},
+		{"bad strategy", `rules: [{name: api, limit: 5, strategy: leaky}]`, `invalid strategy "leaky"`},
+		{"bad window", `rules: [{name: api, limit: 5, window: soon}]`, "invalid window"},
+		{"zero window", `rules: [{name: api, limit: 5, window: 0s}]`, "window must be positive"},
+		{"no limit", `rules: [{name: api}]`, "limit must be positive"},
+		{"negative limit", `rules: [{name: api, limit: -1}]`, "limit must be positive"},
+		{"bad windows", `rules: [{name: api, windows: [10]}]`, "invalid windows"},
+		{"multiple windows with another strategy", `rules: [{name: api, strategy: gcra, windows: [10/1s, 100/1m]}]`, "windows require the fixed_window strategy"},
+		{"single window with another strategy", `rules: [{name: api, strategy: token_bucket, windows: [10/1m]}]`, "windows require the fixed_window strategy"},
+		{"bad block duration", `rules: [{name: api, limit: 5, block_duration: forever}]`, "invalid block_duration"},
+		{"negative block duration", `rules: [{name: api, limit: 5, block_duration: -1s}]`, "block_duration must not be negative"},
+		{"bad on_failure", `rules: [{name: api, limit: 5, on_failure: retry}]`, "failure mode must be"},
+		{
+			"mode all with two non fixed strategies",
+			`{mode: all, rules: [{name: a, limit: 5, strategy: gcra}, {name: b, limit: 5, strategy: token_bucket}]}`,
+			"mode all allows at most one rule",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parsePolicy([]byte(tt.policy))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
+
+func TestParsePolicy_ModeFirstAllowsStrategies(t *testing.T) {
+	_, err := parsePolicy([]byte(`{mode: first, rules: [{name: a, limit: 5, strategy: gcra}, {name: b, limit: 5, strategy: token_bucket}]}`))
+	assert.NoError(t, err)
+}
+
+func TestLoadPolicyFile_JSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"rules": [{"name": "api", "limit": 5, "window": "1m"}]}`), 0o600))
+
+	set, err := LoadPolicyFile(path)
+	require.NoError(t, err)
+	require.Len(t, set.Rules, 1)
+	assert.Equal(t, time.Minute, set.Rules[0].Window)
+
+	_, err = LoadPolicyFile(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+)
+
+// Change is a field whose value differs between two configs.
+type Change struct {
+	Field string
+	Old   string
+	New   string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Field, c.Old, c.New)
+}
+
+// secretFields are masked by Diff.
+var secretFields = map[string]bool{"RedisPassword": true}
+
+// tokenFields are maps keyed by API key, which Diff summarizes instead of
+// printing.
+var tokenFields = map[string]bool{"TokenLimits": true, "TokenPriorities": true}
+
+// Diff returns the fields changed between old and updated. Secrets are
+// masked and maps keyed by API key only report how many tokens changed.
+func Diff(old, updated *Config) []Change {
+	var changes []Change
+
+	oldValue := reflect.ValueOf(old).Elem()
+	newValue := reflect.ValueOf(updated).Elem()
+	for i := 0; i < oldValue.NumField(); i++ {
+		name := oldValue.Type().Field(i).Name
+
+		before := fmt.Sprintf("%v", oldValue.Field(i).Interface())
+		after := fmt.Sprintf("%v", newValue.Field(i).Interface())
+		if before == after {
+			continue
+		}
+
+		switch {
+		case secretFields[name]:
+			before, after = "***", "***"
+		case tokenFields[name]:
+			before, after = summarizeTokens(oldValue.Field(i), newValue.Field(i))
+		}
+		changes = append(changes, Change{Field: name, Old: before, New: after})
+	}
+
+	return changes
+}
+
+// summarizeTokens describes the change between two maps keyed by API key
+// without printing the keys.
+func summarizeTokens(old, updated reflect.Value) (string, string) {
+	changed := 0
+	for _, key := range old.MapKeys() {
+		value := updated.MapIndex(key)
+		if !value.IsValid() || !reflect.DeepEqual(value.Interface(), old.MapIndex(key).Interface()) {
+			changed++
+		}
+	}
+	for _, key := range updated.MapKeys() {
+		if !old.MapIndex(key).IsValid() {
+			changed++
+		}
+	}
+
+	return fmt.Sprintf("%d tokens", old.Len()), fmt.Sprintf("%d tokens (%d changed)", updated.Len(), changed)
+}
+
+// WatchFile calls onChange whenever the modification time or size of path
+// changes, checking every interval until done is closed.
+func WatchFile(path string, interval time.Duration, done <-chan struct{}, onChange func()) {
+	last, _ := os.Stat(path)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			current, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if last == nil || !current.ModTime().Equal(last.ModTime()) || current.Size() != last.Size() {
+				last = current
+				onChange()
+			}
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	old := &Config{
+		RateLimitIP:   10,
+		BlockDuration: time.Minute,
+		RedisPassword: "old-secret",
+		TokenLimits:   map[string]int{"key-a": 100, "key-b": 200},
+	}
+	updated := &Config{
+		RateLimitIP:   20,
+		BlockDuration: time.Minute,
+		RedisPassword: "new-secret",
+		TokenLimits:   map[string]int{"key-a": 100, "key-b": 300, "key-c": 50},
+	}
+
+	changes := Diff(old, updated)
+
+	assert.Equal(t, []Change{
+		{Field: "RateLimitIP", Old: "10", New: "20"},
+		{Field: "RedisPassword", Old: "***", New: "***"},
+		{Field: "TokenLimits", Old: "2 tokens", New: "3 tokens (2 changed)"},
+	}, changes)
+}
+
+func TestDiff_DoesNotLogAPIKeys(t *testing.T) {
+	old := &Config{TokenPriorities: map[string]domain.Priority{"secret-key": domain.PriorityCritical}}
+	updated := &Config{TokenPriorities: map[string]domain.Priority{}}
+
+	changes := Diff(old, updated)
+
+	assert.Len(t, changes, 1)
+	assert.Equal(t, "TokenPriorities: 1 tokens -> 0 tokens (1 changed)", changes[0].String())
+	assert.NotContains(t, changes[0].String(), "secret-key")
+}
+
+func TestDiff_NoChanges(t *testing.T) {
+	cfg := &Config{RateLimitIP: 10, TokenLimits: map[string]int{"key-a": 100}}
+
+	assert.Empty(t, Diff(cfg, &Config{RateLimitIP: 10, TokenLimits: map[string]int{"key-a": 100}}))
+}
@@ -7,17 +7,124 @@ type RateLimitType string
 const (
 	RateLimitTypeIP    RateLimitType = "ip"
 	RateLimitTypeToken RateLimitType = "token"
+	// RateLimitTypeOrganization and RateLimitTypeGlobal are the levels
+	// enclosing a token: all the tokens of an organization and the service.
+	RateLimitTypeOrganization RateLimitType = "org"
+	RateLimitTypeGlobal       RateLimitType = "global"
 )
 
+type Strategy string
+
+const (
+	StrategyFixedWindow   Strategy = "fixed_window"
+	StrategyTokenBucket   Strategy = "token_bucket"
+	StrategySlidingLog    Strategy = "sliding_log"
+	StrategySlidingWindow Strategy = "sliding_window"
+	StrategyGCRA          Strategy = "gcra"
+	// StrategyConcurrency caps in-flight requests instead of requests per
+	// window. It is enforced through RateLimiter.AcquireSlot.
+	StrategyConcurrency Strategy = "concurrency"
+)
+
+// Limit caps the number of requests accepted within a window.
+type Limit struct {
+	MaxRequests int
+	Window      time.Duration
+}
+
+// Period is a calendar period a Quota resets on.
+type Period string
+
+const (
+	PeriodHour  Period = "hour"
+	PeriodDay   Period = "day"
+	PeriodWeek  Period = "week"
+	PeriodMonth Period = "month"
+)
+
+// Quota caps the requests accepted within a calendar period, e.g. 100000
+// per month. Periods start on the boundary in Location (UTC when nil), and
+// weeks start on Monday.
+type Quota struct {
+	MaxRequests int
+	Period      Period
+	Location    *time.Location
+}
+
+// WindowCounter is a fixed window counter checked by AtomicStorage. When
+// NoBlock is set, as for quotas, exceeding it denies until the window
+// resets instead of blocking the key.
+type WindowCounter struct {
+	Key         string
+	MaxRequests int
+	Window      time.Duration
+	NoBlock     bool
+}
+
+// Escalation lengthens the block of a key each time it is blocked again
+// within Memory after its previous block ended. Durations[i] applies to the block
+// following i earlier offenses, and the last one applies from then on.
+type Escalation struct {
+	Durations []time.Duration
+	Memory    time.Duration
+}
+
+// TokenPolicy holds the limits of a single token, replacing the defaults.
+// A zero Window or Strategy keeps the default one, and a zero Burst means
+// MaxRequests. A zero BlockDuration throttles the token without blocking
+// it, so block escalation does not apply either.
+type TokenPolicy struct {
+	MaxRequests   int
+	Window        time.Duration
+	BlockDuration time.Duration
+	Strategy      Strategy
+	Burst         int
+}
+
 type RateLimitConfig struct {
 	Key           string
 	Type          RateLimitType
+	Strategy      Strategy
 	MaxRequests   int
 	BlockDuration time.Duration
+	// Window is the period MaxRequests applies to. Defaults to one second.
+	Window time.Duration
+	// Cost is the number of units the request consumes. Defaults to 1.
+	Cost int
+	// Burst is the token bucket capacity and the GCRA burst tolerance.
+	// Defaults to MaxRequests.
+	Burst int
+	// RefillRate is the number of tokens added per second to the bucket.
+	// Defaults to MaxRequests per Window.
+	RefillRate float64
+	// Lease bounds how long a concurrency slot is held when it is not
+	// released, e.g. after an instance crash. Defaults to 30 seconds.
+	Lease time.Duration
+	// Limits holds several windows evaluated together by the fixed window
+	// strategy, e.g. 10/s, 300/min and 10000/day. When set, it replaces
+	// MaxRequests.
+	Limits []Limit
+	// Escalation replaces BlockDuration for repeat offenders when set.
+	Escalation Escalation
+	// Quotas holds calendar-aligned limits checked together with Limits by
+	// the fixed window strategy.
+	Quotas []Quota
 }
 
 type RateLimitStatus struct {
 	Allowed       bool
 	RemainingReqs int
 	BlockedUntil  time.Time
+	// Window is the window that rejected the request or, when allowed, the
+	// one with the fewest remaining requests. Limit is its maximum number
+	// of requests and ResetAt is when it resets.
+	Window  time.Duration
+	Limit   int
+	ResetAt time.Time
+	// Tripped is set when the request started a new block, as opposed to
+	// arriving while one was active.
+	Tripped bool
+	// Level is the type of the limit the status comes from when several
+	// levels are checked together.
+	Level RateLimitType
 }
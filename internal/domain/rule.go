@@ -0,0 +1,72 @@
+package domain
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// RuleMode selects which of the matching rules apply to a request.
+type RuleMode string
+
+const (
+	// RuleModeFirst applies only the first matching rule.
+	RuleModeFirst RuleMode = "first"
+	// RuleModeAll applies every matching rule; the request must pass all.
+	RuleModeAll RuleMode = "all"
+)
+
+// FailureMode selects what happens to a request when its limits cannot be
+// checked, e.g. because the storage is down.
+type FailureMode string
+
+const (
+	// FailureModeError rejects the request with 500.
+	FailureModeError FailureMode = "error"
+	// FailureModeOpen lets the request through unchecked.
+	FailureModeOpen FailureMode = "open"
+	// FailureModeClosed rejects the request with 503 and Retry-After.
+	FailureModeClosed FailureMode = "closed"
+	// FailureModeLocal checks the limits with an in-process limiter, so
+	// they are enforced per instance.
+	FailureModeLocal FailureMode = "local"
+)
+
+// ParseFailureMode parses "error", "open", "closed" or "local".
+func ParseFailureMode(value string) (FailureMode, error) {
+	switch mode := FailureMode(value); mode {
+	case FailureModeError, FailureModeOpen, FailureModeClosed, FailureModeLocal:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("failure mode must be error, open, closed or local, got %q", value)
+	}
+}
+
+// RuleSet is an ordered list of rules replacing the default IP and token
+// limits for the requests they match.
+type RuleSet struct {
+	Mode  RuleMode
+	Rules []Rule
+}
+
+// Rule matches requests by path, method, headers and identity type and
+// applies its own limits to them, counted per IP or token. Empty match
+// fields match any request.
+type Rule struct {
+	Name       string
+	PathPrefix string
+	PathRegex  *regexp.Regexp
+	Methods    []string
+	// Headers lists headers that must all be present.
+	Headers  []string
+	Identity RateLimitType
+
+	Strategy      Strategy
+	MaxRequests   int
+	Window        time.Duration
+	BlockDuration time.Duration
+	Burst         int
+	Limits        []Limit
+	// OnFailure is empty to use the default failure mode.
+	OnFailure FailureMode
+}
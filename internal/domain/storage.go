@@ -2,14 +2,65 @@ package domain
 
 import (
 	"context"
+	"errors"
 	"time"
 )
 
+var ErrStrategyNotSupported = errors.New("strategy not supported by storage")
+
 type Storage interface {
 	Increment(ctx context.Context, key string, expiration time.Duration) (int64, error)
+	IncrementBy(ctx context.Context, key string, value int64, expiration time.Duration) (int64, error)
 	Get(ctx context.Context, key string) (int64, error)
 	SetBlock(ctx context.Context, key string, duration time.Duration) error
 	IsBlocked(ctx context.Context, key string) (bool, error)
 	GetTTL(ctx context.Context, key string) (time.Duration, error)
+	// AcquireSlot reserves one of limit in-flight slots of key under
+	// leaseID. Slots not released within lease are reclaimed.
+	AcquireSlot(ctx context.Context, key, leaseID string, limit int, lease time.Duration) (bool, error)
+	ReleaseSlot(ctx context.Context, key, leaseID string) error
+	// RecordOffense increments the offense count of key and keeps it for
+	// memory after this offense, so the count decays once the key behaves.
+	RecordOffense(ctx context.Context, key string, memory time.Duration) (int64, error)
 	Close() error
 }
+
+// AtomicStorage is implemented by storages able to check the block key,
+// increment every counter and set the block in a single atomic operation.
+// Counters are only incremented by cost when all of them stay within their
+// limit.
+type AtomicStorage interface {
+	CheckAndBlock(ctx context.Context, blockKey string, counters []WindowCounter, cost int, blockDuration time.Duration) (*RateLimitStatus, error)
+}
+
+// TokenBucketStorage is implemented by storages able to take a token from
+// a bucket refilled continuously at refillRate tokens per second.
+type TokenBucketStorage interface {
+	TakeToken(ctx context.Context, key string, capacity int, refillRate float64, cost int) (*RateLimitStatus, error)
+}
+
+// SlidingWindowStorage is implemented by storages supporting the exact
+// sliding log and the approximate sliding window counter algorithms.
+type SlidingWindowStorage interface {
+	SlidingLog(ctx context.Context, blockKey, key string, maxRequests, cost int, window, blockDuration time.Duration) (*RateLimitStatus, error)
+	SlidingWindowCounter(ctx context.Context, blockKey, key string, maxRequests, cost int, window, blockDuration time.Duration) (*RateLimitStatus, error)
+}
+
+// GCRAStorage is implemented by storages able to evaluate the generic cell
+// rate algorithm keeping only the theoretical arrival time of each key.
+type GCRAStorage interface {
+	GCRA(ctx context.Context, key string, emissionInterval, tolerance time.Duration, cost int) (*RateLimitStatus, error)
+}
+
+// PolicyStore keeps the token policies shared by every instance of the
+// service.
+type PolicyStore interface {
+	SetPolicy(ctx context.Context, token string, policy TokenPolicy) error
+	RemovePolicy(ctx context.Context, token string) error
+	Policy(ctx context.Context, token string) (TokenPolicy, bool, error)
+	Policies(ctx context.Context) (map[string]TokenPolicy, error)
+	// Watch sends the token of every policy changed from now on until ctx
+	// is done. Changes may be missed, e.g. while reconnecting, so stores
+	// should be read in full from time to time.
+	Watch(ctx context.Context) (<-chan string, error)
+}
@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
+)
+
+var ErrCircuitOpen = errors.New("storage circuit breaker is open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitBreakerStorage stops calling storage after failureThreshold
+// consecutive failures, failing fast with ErrCircuitOpen for openDuration.
+// It then lets a few probe calls through, half-open, closing again once
+// they all succeed and reopening on the first failure. Wrap storage in a
+// TimeoutStorage so stalled calls count as failures.
+//
+// Like FailoverStorage, it implements every optional storage interface.
+type CircuitBreakerStorage struct {
+	storage          domain.Storage
+	failureThreshold int
+	openDuration     time.Duration
+	halfOpenProbes   int
+
+	mu        sync.Mutex
+	state     breakerState
+	failures  int
+	openUntil time.Time
+	probes    int
+	successes int
+}
+
+func NewCircuitBreakerStorage(storage domain.Storage, failureThreshold int, openDuration time.Duration) *CircuitBreakerStorage {
+	return &CircuitBreakerStorage{
+		storage:          storage,
+		failureThreshold: failureThreshold,
+		openDuration:     openDuration,
+		halfOpenProbes:   1,
+	}
+}
+
+// SetHalfOpenProbes changes how many probe calls must succeed to close the
+// breaker. The default is one.
+func (b *CircuitBreakerStorage) SetHalfOpenProbes(probes int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.halfOpenProbes = probes
+}
+
+// allow reports whether a call may go through, and whether it is a probe.
+func (b *CircuitBreakerStorage) allow() (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen {
+		if time.Now().Before(b.openUntil) {
+			return false, ErrCircuitOpen
+		}
+		b.transition(breakerHalfOpen)
+	}
+
+	if b.state == breakerHalfOpen {
+		if b.probes >= b.halfOpenProbes {
+			return false, ErrCircuitOpen
+		}
+		b.probes++
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// record updates the breaker with the result of a call. Errors caused by
+// the caller, such as a canceled context or a missing strategy, count as
+// neither a failure nor a success.
+func (b *CircuitBreakerStorage) record(ctx context.Context, probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probes--
+	}
+	if err != nil && (ctx.Err() != nil || errors.Is(err, domain.ErrStrategyNotSupported)) {
+		return
+	}
+
+	switch {
+	case err == nil && b.state == breakerHalfOpen:
+		// Calls started before the breaker opened may still finish while
+		// half-open; only probes decide whether to close it.
+		if !probe {
+			return
+		}
+		b.successes++
+		if b.successes >= b.halfOpenProbes {
+			b.transition(breakerClosed)
+		}
+	case err == nil:
+		b.failures = 0
+	case b.state == breakerHalfOpen:
+		b.transition(breakerOpen)
+	case b.state == breakerClosed:
+		b.failures++
+		if b.failures >= b.failureThreshold {
+			log.Printf("Storage failed %d times in a row: %v", b.failures, err)
+			b.transition(breakerOpen)
+		}
+	}
+}
+
+// transition must be called with the lock held.
+func (b *CircuitBreakerStorage) transition(state breakerState) {
+	b.state = state
+	b.failures = 0
+	b.probes = 0
+	b.successes = 0
+	if state == breakerOpen {
+		b.openUntil = time.Now().Add(b.openDuration)
+	}
+	log.Printf("Storage circuit breaker %s", state)
+}
+
+func (b *CircuitBreakerStorage) do(ctx context.Context, op func(ctx context.Context) error) error {
+	probe, err := b.allow()
+	if err != nil {
+		return err
+	}
+
+	err = op(ctx)
+	b.record(ctx, probe, err)
+	return err
+}
+
+func (b *CircuitBreakerStorage) Increment(ctx context.Context, key string, expiration time.Duration) (int64, error) {
+	return b.IncrementBy(ctx, key, 1, expiration)
+}
+
+func (b *CircuitBreakerStorage) IncrementBy(ctx context.Context, key string, value int64, expiration time.Duration) (int64, error) {
+	var count int64
+	err := b.do(ctx, func(ctx context.Context) (err error) {
+		count, err = b.storage.IncrementBy(ctx, key, value, expiration)
+		return err
+	})
+	return count, err
+}
+
+func (b *CircuitBreakerStorage) Get(ctx context.Context, key string) (int64, error) {
+	var value int64
+	err := b.do(ctx, func(ctx context.Context) (err error) {
+		value, err = b.storage.Get(ctx, key)
+		return err
+	})
+	return value, err
+}
+
+func (b *CircuitBreakerStorage) SetBlock(ctx context.Context, key string, duration time.Duration) error {
+	return b.do(ctx, func(ctx context.Context) error {
+		return b.storage.SetBlock(ctx, key, duration)
+	})
+}
+
+func (b *CircuitBreakerStorage) IsBlocked(ctx context.Context, key string) (bool, error) {
+	var blocked bool
+	err := b.do(ctx, func(ctx context.Context) (err error) {
+		blocked, err = b.storage.IsBlocked(ctx, key)
+		return err
+	})
+	return blocked, err
+}
+
+func (b *CircuitBreakerStorage) GetTTL(ctx context.Context, key string) (time.Duration, error) {
+	var ttl time.Duration
+	err := b.do(ctx, func(ctx context.Context) (err error) {
+		ttl, err = b.storage.GetTTL(ctx, key)
+		return err
+	})
+	return ttl, err
+}
+
+func (b *CircuitBreakerStorage) AcquireSlot(ctx context.Context, key, leaseID string, limit int, lease time.Duration) (bool, error) {
+	var acquired bool
+	err := b.do(ctx, func(ctx context.Context) (err error) {
+		acquired, err = b.storage.AcquireSlot(ctx, key, leaseID, limit, lease)
+		return err
+	})
+	return acquired, err
+}
+
+func (b *CircuitBreakerStorage) ReleaseSlot(ctx context.Context, key, leaseID string) error {
+	return b.do(ctx, func(ctx context.Context) error {
+		return b.storage.ReleaseSlot(ctx, key, leaseID)
+	})
+}
+
+func (b *CircuitBreakerStorage) RecordOffense(ctx context.Context, key string, memory time.Duration) (int64, error) {
+	var count int64
+	err := b.do(ctx, func(ctx context.Context) (err error) {
+		count, err = b.storage.RecordOffense(ctx, key, memory)
+		return err
+	})
+	return count, err
+}
+
+func (b *CircuitBreakerStorage) CheckAndBlock(ctx context.Context, blockKey string, counters []domain.WindowCounter, cost int, blockDuration time.Duration) (*domain.RateLimitStatus, error) {
+	return b.status(ctx, func(ctx context.Context) (*domain.RateLimitStatus, error) {
+		return checkAndBlock(ctx, b.storage, blockKey, counters, cost, blockDuration)
+	})
+}
+
+func (b *CircuitBreakerStorage) TakeToken(ctx context.Context, key string, capacity int, refillRate float64, cost int) (*domain.RateLimitStatus, error) {
+	return b.status(ctx, func(ctx context.Context) (*domain.RateLimitStatus, error) {
+		return takeToken(ctx, b.storage, key, capacity, refillRate, cost)
+	})
+}
+
+func (b *CircuitBreakerStorage) SlidingLog(ctx context.Context, blockKey, key string, maxRequests, cost int, window, blockDuration time.Duration) (*domain.RateLimitStatus, error) {
+	return b.status(ctx, func(ctx context.Context) (*domain.RateLimitStatus, error) {
+		return slidingLog(ctx, b.storage, blockKey, key, maxRequests, cost, window, blockDuration)
+	})
+}
+
+func (b *CircuitBreakerStorage) SlidingWindowCounter(ctx context.Context, blockKey, key string, maxRequests, cost int, window, blockDuration time.Duration) (*domain.RateLimitStatus, error) {
+	return b.status(ctx, func(ctx context.Context) (*domain.RateLimitStatus, error) {
+		return slidingWindowCounter(ctx, b.storage, blockKey, key, maxRequests, cost, window, blockDuration)
+	})
+}
+
+func (b *CircuitBreakerStorage) GCRA(ctx context.Context, key string, emissionInterval, tolerance time.Duration, cost int) (*domain.RateLimitStatus, error) {
+	return b.status(ctx, func(ctx context.Context) (*domain.RateLimitStatus, error) {
+		return gcra(ctx, b.storage, key, emissionInterval, tolerance, cost)
+	})
+}
+
+func (b *CircuitBreakerStorage) status(ctx context.Context, op func(ctx context.Context) (*domain.RateLimitStatus, error)) (*domain.RateLimitStatus, error) {
+	var status *domain.RateLimitStatus
+	err := b.do(ctx, func(ctx context.Context) (err error) {
+		status, err = op(ctx)
+		return err
+	})
+	return status, err
+}
+
+func (b *CircuitBreakerStorage) Close() error {
+	return b.storage.Close()
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowStorage answers Get after delay, unless the context ends first.
+type slowStorage struct {
+	*MemoryStorage
+	delay atomic.Int64
+	calls atomic.Int64
+}
+
+func (s *slowStorage) Get(ctx context.Context, key string) (int64, error) {
+	s.calls.Add(1)
+	select {
+	case <-time.After(time.Duration(s.delay.Load())):
+		return s.MemoryStorage.Get(ctx, key)
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+func TestCircuitBreakerStorage(t *testing.T) {
+	inner := &slowStorage{MemoryStorage: NewMemoryStorage()}
+	inner.delay.Store(int64(time.Second))
+	store := NewCircuitBreakerStorage(NewTimeoutStorage(inner, 10*time.Millisecond), 2, 50*time.Millisecond)
+	store.SetHalfOpenProbes(2)
+	defer store.Close()
+
+	assert.Implements(t, (*domain.AtomicStorage)(nil), store)
+	assert.Implements(t, (*domain.GCRAStorage)(nil), store)
+
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		_, err := store.Get(ctx, "count:test")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+	}
+
+	_, err := store.Get(ctx, "count:test")
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, int64(2), inner.calls.Load())
+
+	inner.delay.Store(0)
+	time.Sleep(60 * time.Millisecond)
+
+	_, err = store.Get(ctx, "count:test")
+	require.NoError(t, err)
+	assert.Equal(t, breakerHalfOpen, store.state)
+
+	_, err = store.Get(ctx, "count:test")
+	require.NoError(t, err)
+	assert.Equal(t, breakerClosed, store.state)
+}
+
+func TestCircuitBreakerStorage_HalfOpenFailure(t *testing.T) {
+	inner := &slowStorage{MemoryStorage: NewMemoryStorage()}
+	inner.delay.Store(int64(time.Second))
+	store := NewCircuitBreakerStorage(NewTimeoutStorage(inner, 10*time.Millisecond), 1, 20*time.Millisecond)
+	defer store.Close()
+
+	ctx := context.Background()
+
+	_, err := store.Get(ctx, "count:test")
+	assert.Error(t, err)
+	assert.Equal(t, breakerOpen, store.state)
+
+	time.Sleep(30 * time.Millisecond)
+
+	_, err = store.Get(ctx, "count:test")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, breakerOpen, store.state)
+}
+
+func TestCircuitBreakerStorage_CallerErrors(t *testing.T) {
+	inner := &slowStorage{MemoryStorage: NewMemoryStorage()}
+	inner.delay.Store(int64(time.Second))
+	store := NewCircuitBreakerStorage(NewTimeoutStorage(inner, time.Second), 1, time.Minute)
+	defer store.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := store.Get(ctx, "count:test")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, breakerClosed, store.state)
+}
+
+// gatedStorage fails Get for "fail" and holds Get for the keys of gates
+// until their channel is closed.
+type gatedStorage struct {
+	*MemoryStorage
+	gates map[string]chan struct{}
+}
+
+func (g *gatedStorage) Get(ctx context.Context, key string) (int64, error) {
+	if key == "fail" {
+		return 0, errors.New("connection refused")
+	}
+	if gate, exists := g.gates[key]; exists {
+		<-gate
+	}
+	return g.MemoryStorage.Get(ctx, key)
+}
+
+func TestCircuitBreakerStorage_OnlyProbesClose(t *testing.T) {
+	inner := &gatedStorage{
+		MemoryStorage: NewMemoryStorage(),
+		gates:         map[string]chan struct{}{"late": make(chan struct{}), "probe": make(chan struct{})},
+	}
+	store := NewCircuitBreakerStorage(inner, 1, 20*time.Millisecond)
+	defer store.Close()
+
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	get := func(key string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.Get(ctx, key)
+			assert.NoError(t, err)
+		}()
+	}
+
+	// Started while closed, it finishes once the breaker is half-open.
+	get("late")
+	time.Sleep(10 * time.Millisecond)
+
+	_, err := store.Get(ctx, "fail")
+	assert.Error(t, err)
+	time.Sleep(30 * time.Millisecond)
+
+	get("probe")
+	time.Sleep(10 * time.Millisecond)
+
+	close(inner.gates["late"])
+	time.Sleep(10 * time.Millisecond)
+	store.mu.Lock()
+	assert.Equal(t, breakerHalfOpen, store.state)
+	store.mu.Unlock()
+
+	close(inner.gates["probe"])
+	wg.Wait()
+	assert.Equal(t, breakerClosed, store.state)
+}
+
+func TestTimeoutStorage(t *testing.T) {
+	inner := &slowStorage{MemoryStorage: NewMemoryStorage()}
+	inner.delay.Store(int64(time.Second))
+	store := NewTimeoutStorage(inner, 10*time.Millisecond)
+	defer store.Close()
+
+	assert.Implements(t, (*domain.AtomicStorage)(nil), store)
+
+	start := time.Now()
+	_, err := store.Get(context.Background(), "count:test")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+	inner.delay.Store(0)
+	_, err = store.Get(context.Background(), "count:test")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
+)
+
+// The functions below call an optional interface of store, returning
+// domain.ErrStrategyNotSupported when store lacks it, so storages wrapping
+// another one can forward every strategy.
+
+func checkAndBlock(ctx context.Context, store domain.Storage, blockKey string, counters []domain.WindowCounter, cost int, blockDuration time.Duration) (*domain.RateLimitStatus, error) {
+	atomic, ok := store.(domain.AtomicStorage)
+	if !ok {
+		return nil, domain.ErrStrategyNotSupported
+	}
+	return atomic.CheckAndBlock(ctx, blockKey, counters, cost, blockDuration)
+}
+
+func takeToken(ctx context.Context, store domain.Storage, key string, capacity int, refillRate float64, cost int) (*domain.RateLimitStatus, error) {
+	buckets, ok := store.(domain.TokenBucketStorage)
+	if !ok {
+		return nil, domain.ErrStrategyNotSupported
+	}
+	return buckets.TakeToken(ctx, key, capacity, refillRate, cost)
+}
+
+func slidingLog(ctx context.Context, store domain.Storage, blockKey, key string, maxRequests, cost int, window, blockDuration time.Duration) (*domain.RateLimitStatus, error) {
+	windows, ok := store.(domain.SlidingWindowStorage)
+	if !ok {
+		return nil, domain.ErrStrategyNotSupported
+	}
+	return windows.SlidingLog(ctx, blockKey, key, maxRequests, cost, window, blockDuration)
+}
+
+func slidingWindowCounter(ctx context.Context, store domain.Storage, blockKey, key string, maxRequests, cost int, window, blockDuration time.Duration) (*domain.RateLimitStatus, error) {
+	windows, ok := store.(domain.SlidingWindowStorage)
+	if !ok {
+		return nil, domain.ErrStrategyNotSupported
+	}
+	return windows.SlidingWindowCounter(ctx, blockKey, key, maxRequests, cost, window, blockDuration)
+}
+
+func gcra(ctx context.Context, store domain.Storage, key string, emissionInterval, tolerance time.Duration, cost int) (*domain.RateLimitStatus, error) {
+	cells, ok := store.(domain.GCRAStorage)
+	if !ok {
+		return nil, domain.ErrStrategyNotSupported
+	}
+	return cells.GCRA(ctx, key, emissionInterval, tolerance, cost)
+}
@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
+)
+
+// probeKey is read from the primary storage to check if it is back.
+const probeKey = "failover:probe"
+
+// FailoverStorage uses primary, usually Redis, until one of its calls fails.
+// The call is then retried on fallback, usually a MemoryStorage, which
+// serves every call until primary answers a probe again. Limits are only
+// enforced per instance while on fallback, and counters are not copied
+// between the storages in either direction.
+//
+// It implements every optional storage interface; calls return
+// domain.ErrStrategyNotSupported when the active storage lacks one, so
+// both storages should support the same strategies.
+type FailoverStorage struct {
+	primary       domain.Storage
+	fallback      domain.Storage
+	probeInterval time.Duration
+
+	mu     sync.RWMutex
+	failed bool
+
+	done chan struct{}
+}
+
+func NewFailoverStorage(primary, fallback domain.Storage, probeInterval time.Duration) *FailoverStorage {
+	storage := &FailoverStorage{
+		primary:       primary,
+		fallback:      fallback,
+		probeInterval: probeInterval,
+		done:          make(chan struct{}),
+	}
+
+	go storage.probe()
+
+	return storage
+}
+
+// Failed reports whether the fallback storage is in use.
+func (f *FailoverStorage) Failed() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.failed
+}
+
+func (f *FailoverStorage) probe() {
+	ticker := time.NewTicker(f.probeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-ticker.C:
+		}
+
+		if !f.Failed() {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), f.probeInterval)
+		_, err := f.primary.Get(ctx, probeKey)
+		cancel()
+		if err != nil {
+			continue
+		}
+
+		f.mu.Lock()
+		f.failed = false
+		f.mu.Unlock()
+		log.Println("Primary storage recovered, switching back from fallback storage")
+	}
+}
+
+// do runs op on the active storage, switching to fallback and running op
+// again there when primary fails. Errors caused by the caller, such as a
+// canceled context or a missing strategy, do not trigger a switch.
+func (f *FailoverStorage) do(ctx context.Context, op func(store domain.Storage) error) error {
+	if f.Failed() {
+		return op(f.fallback)
+	}
+
+	err := op(f.primary)
+	if err == nil || ctx.Err() != nil || errors.Is(err, domain.ErrStrategyNotSupported) {
+		return err
+	}
+
+	f.mu.Lock()
+	if !f.failed {
+		f.failed = true
+		log.Printf("Primary storage failed, switching to fallback storage: %v", err)
+	}
+	f.mu.Unlock()
+
+	return op(f.fallback)
+}
+
+func (f *FailoverStorage) Increment(ctx context.Context, key string, expiration time.Duration) (int64, error) {
+	return f.IncrementBy(ctx, key, 1, expiration)
+}
+
+func (f *FailoverStorage) IncrementBy(ctx context.Context, key string, value int64, expiration time.Duration) (int64, error) {
+	var count int64
+	err := f.do(ctx, func(store domain.Storage) (err error) {
+		count, err = store.IncrementBy(ctx, key, value, expiration)
+		return err
+	})
+	return count, err
+}
+
+func (f *FailoverStorage) Get(ctx context.Context, key string) (int64, error) {
+	var value int64
+	err := f.do(ctx, func(store domain.Storage) (err error) {
+		value, err = store.Get(ctx, key)
+		return err
+	})
+	return value, err
+}
+
+func (f *FailoverStorage) SetBlock(ctx context.Context, key string, duration time.Duration) error {
+	return f.do(ctx, func(store domain.Storage) error {
+		return store.SetBlock(ctx, key, duration)
+	})
+}
+
+func (f *FailoverStorage) IsBlocked(ctx context.Context, key string) (bool, error) {
+	var blocked bool
+	err := f.do(ctx, func(store domain.Storage) (err error) {
+		blocked, err = store.IsBlocked(ctx, key)
+		return err
+	})
+	return blocked, err
+}
+
+func (f *FailoverStorage) GetTTL(ctx context.Context, key string) (time.Duration, error) {
+	var ttl time.Duration
+	err := f.do(ctx, func(store domain.Storage) (err error) {
+		ttl, err = store.GetTTL(ctx, key)
+		return err
+	})
+	return ttl, err
+}
+
+func (f *FailoverStorage) AcquireSlot(ctx context.Context, key, leaseID string, limit int, lease time.Duration) (bool, error) {
+	var acquired bool
+	err := f.do(ctx, func(store domain.Storage) (err error) {
+		acquired, err = store.AcquireSlot(ctx, key, leaseID, limit, lease)
+		return err
+	})
+	return acquired, err
+}
+
+// ReleaseSlot releases the slot on the active storage. Slots acquired on
+// the other one are reclaimed once their lease ends.
+func (f *FailoverStorage) ReleaseSlot(ctx context.Context, key, leaseID string) error {
+	return f.do(ctx, func(store domain.Storage) error {
+		return store.ReleaseSlot(ctx, key, leaseID)
+	})
+}
+
+func (f *FailoverStorage) RecordOffense(ctx context.Context, key string, memory time.Duration) (int64, error) {
+	var count int64
+	err := f.do(ctx, func(store domain.Storage) (err error) {
+		count, err = store.RecordOffense(ctx, key, memory)
+		return err
+	})
+	return count, err
+}
+
+func (f *FailoverStorage) CheckAndBlock(ctx context.Context, blockKey string, counters []domain.WindowCounter, cost int, blockDuration time.Duration) (*domain.RateLimitStatus, error) {
+	return f.status(ctx, func(store domain.Storage) (*domain.RateLimitStatus, error) {
+		return checkAndBlock(ctx, store, blockKey, counters, cost, blockDuration)
+	})
+}
+
+func (f *FailoverStorage) TakeToken(ctx context.Context, key string, capacity int, refillRate float64, cost int) (*domain.RateLimitStatus, error) {
+	return f.status(ctx, func(store domain.Storage) (*domain.RateLimitStatus, error) {
+		return takeToken(ctx, store, key, capacity, refillRate, cost)
+	})
+}
+
+func (f *FailoverStorage) SlidingLog(ctx context.Context, blockKey, key string, maxRequests, cost int, window, blockDuration time.Duration) (*domain.RateLimitStatus, error) {
+	return f.status(ctx, func(store domain.Storage) (*domain.RateLimitStatus, error) {
+		return slidingLog(ctx, store, blockKey, key, maxRequests, cost, window, blockDuration)
+	})
+}
+
+func (f *FailoverStorage) SlidingWindowCounter(ctx context.Context, blockKey, key string, maxRequests, cost int, window, blockDuration time.Duration) (*domain.RateLimitStatus, error) {
+	return f.status(ctx, func(store domain.Storage) (*domain.RateLimitStatus, error) {
+		return slidingWindowCounter(ctx, store, blockKey, key, maxRequests, cost, window, blockDuration)
+	})
+}
+
+func (f *FailoverStorage) GCRA(ctx context.Context, key string, emissionInterval, tolerance time.Duration, cost int) (*domain.RateLimitStatus, error) {
+	return f.status(ctx, func(store domain.Storage) (*domain.RateLimitStatus, error) {
+		return gcra(ctx, store, key, emissionInterval, tolerance, cost)
+	})
+}
+
+func (f *FailoverStorage) status(ctx context.Context, op func(store domain.Storage) (*domain.RateLimitStatus, error)) (*domain.RateLimitStatus, error) {
+	var status *domain.RateLimitStatus
+	err := f.do(ctx, func(store domain.Storage) (err error) {
+		status, err = op(store)
+		return err
+	})
+	return status, err
+}
+
+func (f *FailoverStorage) Close() error {
+	close(f.done)
+
+	return errors.Join(f.primary.Close(), f.fallback.Close())
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyStorage fails every call while down is set.
+type flakyStorage struct {
+	*MemoryStorage
+	down atomic.Bool
+}
+
+var errDown = errors.New("connection refused")
+
+func (s *flakyStorage) IncrementBy(ctx context.Context, key string, value int64, expiration time.Duration) (int64, error) {
+	if s.down.Load() {
+		return 0, errDown
+	}
+	return s.MemoryStorage.IncrementBy(ctx, key, value, expiration)
+}
+
+func (s *flakyStorage) Get(ctx context.Context, key string) (int64, error) {
+	if s.down.Load() {
+		return 0, errDown
+	}
+	return s.MemoryStorage.Get(ctx, key)
+}
+
+func (s *flakyStorage) CheckAndBlock(ctx context.Context, blockKey string, counters []domain.WindowCounter, cost int, blockDuration time.Duration) (*domain.RateLimitStatus, error) {
+	if s.down.Load() {
+		return nil, errDown
+	}
+	return s.MemoryStorage.CheckAndBlock(ctx, blockKey, counters, cost, blockDuration)
+}
+
+func TestFailoverStorage(t *testing.T) {
+	primary := &flakyStorage{MemoryStorage: NewMemoryStorage()}
+	fallback := NewMemoryStorage()
+	store := NewFailoverStorage(primary, fallback, 20*time.Millisecond)
+	defer store.Close()
+
+	assert.Implements(t, (*domain.AtomicStorage)(nil), store)
+	assert.Implements(t, (*domain.TokenBucketStorage)(nil), store)
+	assert.Implements(t, (*domain.SlidingWindowStorage)(nil), store)
+	assert.Implements(t, (*domain.GCRAStorage)(nil), store)
+
+	ctx := context.Background()
+	counters := []domain.WindowCounter{{Key: "count:test", MaxRequests: 10, Window: time.Minute}}
+
+	status, err := store.CheckAndBlock(ctx, "block:test", counters, 1, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 9, status.RemainingReqs)
+	assert.False(t, store.Failed())
+
+	primary.down.Store(true)
+
+	status, err = store.CheckAndBlock(ctx, "block:test", counters, 1, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 9, status.RemainingReqs)
+	assert.True(t, store.Failed())
+
+	count, err := store.Increment(ctx, "count:other", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, store.Failed())
+
+	primary.down.Store(false)
+	assert.Eventually(t, func() bool { return !store.Failed() }, time.Second, 10*time.Millisecond)
+
+	status, err = store.CheckAndBlock(ctx, "block:test", counters, 1, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 8, status.RemainingReqs)
+}
+
+func TestFailoverStorage_CallerErrors(t *testing.T) {
+	primary := &flakyStorage{MemoryStorage: NewMemoryStorage()}
+	primary.down.Store(true)
+	store := NewFailoverStorage(primary, NewMemoryStorage(), time.Minute)
+	defer store.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := store.Get(ctx, "count:test")
+	assert.ErrorIs(t, err, errDown)
+	assert.False(t, store.Failed())
+}
@@ -2,23 +2,98 @@ package storage
 
 import (
 	"context"
+	"math"
 	"sync"
 	"time"
+
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
 )
 
 type entry struct {
 	value     int64
 	expiresAt time.Time
+	block     bool
+}
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	expiresAt time.Time
+}
+
+// ringLog holds the request times of a sliding log, oldest first from
+// start. It grows as requests arrive, up to limit entries, so keys making
+// few requests stay small even under large limits.
+type ringLog struct {
+	times     []time.Time
+	start     int
+	count     int
+	limit     int
+	expiresAt time.Time
+}
+
+// grow makes room for n more times, doubling the ring up to limit.
+func (r *ringLog) grow(n int) {
+	needed := r.count + n
+	if needed <= len(r.times) {
+		return
+	}
+
+	size := max(needed, 2*len(r.times))
+	size = min(size, r.limit)
+
+	times := make([]time.Time, size)
+	for i := 0; i < r.count; i++ {
+		times[i] = r.times[(r.start+i)%len(r.times)]
+	}
+	r.times, r.start = times, 0
+}
+
+// setLimit changes the limit of the log. Every time is kept, like the
+// Redis log does, so a lower limit denies until enough of them leave the
+// window; only spare room over the new limit is released.
+func (r *ringLog) setLimit(limit int) {
+	r.limit = limit
+
+	size := max(r.count, limit)
+	if len(r.times) <= size {
+		return
+	}
+
+	times := make([]time.Time, size)
+	for i := 0; i < r.count; i++ {
+		times[i] = r.times[(r.start+i)%len(r.times)]
+	}
+	r.times, r.start = times, 0
+}
+
+type windowCounter struct {
+	index     int64
+	current   int64
+	previous  int64
+	expiresAt time.Time
 }
 
 type MemoryStorage struct {
-	data map[string]*entry
-	mu   sync.RWMutex
+	data       map[string]*entry
+	buckets    map[string]*bucket
+	logs       map[string]*ringLog
+	windows    map[string]*windowCounter
+	slots      map[string]map[string]time.Time
+	lru        *lru
+	maxEntries int
+	evictions  int64
+	mu         sync.RWMutex
 }
 
 func NewMemoryStorage() *MemoryStorage {
 	storage := &MemoryStorage{
-		data: make(map[string]*entry),
+		data:    make(map[string]*entry),
+		buckets: make(map[string]*bucket),
+		logs:    make(map[string]*ringLog),
+		windows: make(map[string]*windowCounter),
+		slots:   make(map[string]map[string]time.Time),
+		lru:     newLRU(),
 	}
 
 	go storage.cleanupExpired()
@@ -26,6 +101,27 @@ func NewMemoryStorage() *MemoryStorage {
 	return storage
 }
 
+// SetMaxEntries bounds the keys kept, counting every counter, bucket, log
+// and block, so clients rotating addresses cannot exhaust memory. Over the
+// bound, the least recently used keys are evicted, blocks only once no
+// other key is left. Evicting a counter resets it. Zero, the default,
+// keeps every key until it expires.
+func (m *MemoryStorage) SetMaxEntries(maxEntries int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.maxEntries = maxEntries
+	m.evict()
+}
+
+// Evictions returns how many keys were evicted to stay within the bound.
+func (m *MemoryStorage) Evictions() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.evictions
+}
+
 func (m *MemoryStorage) cleanupExpired() {
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
@@ -36,6 +132,32 @@ func (m *MemoryStorage) cleanupExpired() {
 		for key, entry := range m.data {
 			if !entry.expiresAt.IsZero() && now.After(entry.expiresAt) {
 				delete(m.data, key)
+				m.lru.forget(kindData, key)
+			}
+		}
+		for key, bucket := range m.buckets {
+			if now.After(bucket.expiresAt) {
+				delete(m.buckets, key)
+				m.lru.forget(kindBucket, key)
+			}
+		}
+		for key, history := range m.logs {
+			if now.After(history.expiresAt) {
+				delete(m.logs, key)
+				m.lru.forget(kindLog, key)
+			}
+		}
+		for key, counter := range m.windows {
+			if now.After(counter.expiresAt) {
+				delete(m.windows, key)
+				m.lru.forget(kindWindow, key)
+			}
+		}
+		for key, leases := range m.slots {
+			releaseExpired(leases, now)
+			if len(leases) == 0 {
+				delete(m.slots, key)
+				m.lru.forget(kindSlots, key)
 			}
 		}
 		m.mu.Unlock()
@@ -43,6 +165,10 @@ func (m *MemoryStorage) cleanupExpired() {
 }
 
 func (m *MemoryStorage) Increment(ctx context.Context, key string, expiration time.Duration) (int64, error) {
+	return m.IncrementBy(ctx, key, 1, expiration)
+}
+
+func (m *MemoryStorage) IncrementBy(ctx context.Context, key string, value int64, expiration time.Duration) (int64, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -55,16 +181,37 @@ func (m *MemoryStorage) Increment(ctx context.Context, key string, expiration ti
 	}
 
 	if entry, exists := m.data[key]; exists {
-		entry.value++
+		entry.value += value
+		m.touch(kindData, key, entry.block)
 		return entry.value, nil
 	}
 
 	m.data[key] = &entry{
-		value:     1,
+		value:     value,
 		expiresAt: now.Add(expiration),
 	}
+	m.touch(kindData, key, false)
 
-	return 1, nil
+	return value, nil
+}
+
+func (m *MemoryStorage) RecordOffense(ctx context.Context, key string, memory time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	offenses := &entry{}
+	if current, exists := m.data[key]; exists && now.Before(current.expiresAt) {
+		offenses = current
+	}
+
+	offenses.value++
+	offenses.expiresAt = now.Add(memory)
+	m.data[key] = offenses
+	m.touch(kindData, key, false)
+
+	return offenses.value, nil
 }
 
 func (m *MemoryStorage) Get(ctx context.Context, key string) (int64, error) {
@@ -87,11 +234,7 @@ func (m *MemoryStorage) SetBlock(ctx context.Context, key string, duration time.
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	m.data[key] = &entry{
-		value:     1,
-		expiresAt: time.Now().Add(duration),
-	}
-
+	m.setBlock(key, time.Now().Add(duration))
 	return nil
 }
 
@@ -132,10 +275,379 @@ func (m *MemoryStorage) GetTTL(ctx context.Context, key string) (time.Duration,
 	return ttl, nil
 }
 
+func (m *MemoryStorage) AcquireSlot(ctx context.Context, key, leaseID string, limit int, lease time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	leases, exists := m.slots[key]
+	if !exists {
+		leases = make(map[string]time.Time)
+		m.slots[key] = leases
+	}
+	m.touch(kindSlots, key, false)
+	releaseExpired(leases, now)
+
+	if len(leases) >= limit {
+		return false, nil
+	}
+
+	leases[leaseID] = now.Add(lease)
+	return true, nil
+}
+
+func (m *MemoryStorage) ReleaseSlot(ctx context.Context, key, leaseID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if leases, exists := m.slots[key]; exists {
+		delete(leases, leaseID)
+	}
+	return nil
+}
+
+func releaseExpired(leases map[string]time.Time, now time.Time) {
+	for id, expiresAt := range leases {
+		if now.After(expiresAt) {
+			delete(leases, id)
+		}
+	}
+}
+
+func (m *MemoryStorage) CheckAndBlock(ctx context.Context, blockKey string, counters []domain.WindowCounter, cost int, blockDuration time.Duration) (*domain.RateLimitStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	if blockedUntil, blocked := m.blockedUntil(blockKey, now); blocked {
+		return &domain.RateLimitStatus{
+			Allowed:       false,
+			RemainingReqs: 0,
+			BlockedUntil:  blockedUntil,
+			ResetAt:       blockedUntil,
+		}, nil
+	}
+
+	entries := make([]*entry, len(counters))
+	for i, counter := range counters {
+		current, exists := m.data[counter.Key]
+		if !exists || (!current.expiresAt.IsZero() && now.After(current.expiresAt)) {
+			current = &entry{expiresAt: now.Add(counter.Window)}
+		}
+
+		if int(current.value)+cost > counter.MaxRequests {
+			tripped := blockDuration > 0 && !counter.NoBlock
+			blockedUntil := current.expiresAt
+			if tripped {
+				blockedUntil = now.Add(blockDuration)
+				m.setBlock(blockKey, blockedUntil)
+			}
+
+			return &domain.RateLimitStatus{
+				Allowed:       false,
+				RemainingReqs: 0,
+				BlockedUntil:  blockedUntil,
+				Window:        counter.Window,
+				Limit:         counter.MaxRequests,
+				ResetAt:       current.expiresAt,
+				Tripped:       tripped,
+			}, nil
+		}
+
+		entries[i] = current
+	}
+
+	status := &domain.RateLimitStatus{Allowed: true, RemainingReqs: -1}
+	keep := make([]lruKey, len(counters))
+	for i, counter := range counters {
+		entries[i].value += int64(cost)
+		m.data[counter.Key] = entries[i]
+		m.lru.touch(kindData, counter.Key, false)
+		keep[i] = lruKey{kind: kindData, key: counter.Key}
+
+		remaining := counter.MaxRequests - int(entries[i].value)
+		if status.RemainingReqs < 0 || remaining < status.RemainingReqs {
+			status.RemainingReqs = remaining
+			status.Window = counter.Window
+			status.Limit = counter.MaxRequests
+			status.ResetAt = entries[i].expiresAt
+		}
+	}
+	m.evict(keep...)
+
+	return status, nil
+}
+
+func (m *MemoryStorage) TakeToken(ctx context.Context, key string, capacity int, refillRate float64, cost int) (*domain.RateLimitStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	b, exists := m.buckets[key]
+	if !exists || now.After(b.expiresAt) {
+		b = &bucket{tokens: float64(capacity), updatedAt: now}
+		m.buckets[key] = b
+	}
+	m.touch(kindBucket, key, false)
+
+	elapsed := now.Sub(b.updatedAt).Seconds()
+	b.tokens = math.Min(float64(capacity), b.tokens+elapsed*refillRate)
+	b.updatedAt = now
+	b.expiresAt = now.Add(time.Duration(float64(capacity) / refillRate * float64(time.Second)))
+
+	if b.tokens < float64(cost) {
+		wait := time.Duration((float64(cost) - b.tokens) / refillRate * float64(time.Second))
+		return &domain.RateLimitStatus{
+			Allowed:       false,
+			RemainingReqs: 0,
+			BlockedUntil:  now.Add(wait),
+		}, nil
+	}
+
+	b.tokens -= float64(cost)
+
+	return &domain.RateLimitStatus{
+		Allowed:       true,
+		RemainingReqs: int(b.tokens),
+		BlockedUntil:  time.Time{},
+	}, nil
+}
+
+func (m *MemoryStorage) SlidingLog(ctx context.Context, blockKey, key string, maxRequests, cost int, window, blockDuration time.Duration) (*domain.RateLimitStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	if blockedUntil, blocked := m.blockedUntil(blockKey, now); blocked {
+		return &domain.RateLimitStatus{
+			Allowed:       false,
+			RemainingReqs: 0,
+			BlockedUntil:  blockedUntil,
+		}, nil
+	}
+
+	history, exists := m.logs[key]
+	if !exists {
+		history = &ringLog{limit: maxRequests}
+		m.logs[key] = history
+	} else if history.limit != maxRequests {
+		history.setLimit(maxRequests)
+	}
+	m.touch(kindLog, key, false)
+
+	for history.count > 0 && !history.times[history.start].After(now.Add(-window)) {
+		history.start = (history.start + 1) % len(history.times)
+		history.count--
+	}
+
+	if history.count+cost > maxRequests {
+		blockedUntil := now.Add(window)
+		if history.count > 0 && cost <= maxRequests {
+			// Enough of the oldest entries must leave the window to fit cost.
+			oldest := (history.start + history.count + cost - maxRequests - 1) % len(history.times)
+			blockedUntil = history.times[oldest].Add(window)
+		}
+		if blockDuration > 0 {
+			blockedUntil = now.Add(blockDuration)
+			m.setBlock(blockKey, blockedUntil)
+		}
+
+		return &domain.RateLimitStatus{
+			Allowed:       false,
+			RemainingReqs: 0,
+			BlockedUntil:  blockedUntil,
+			Tripped:       blockDuration > 0,
+		}, nil
+	}
+
+	history.grow(cost)
+	for i := 0; i < cost; i++ {
+		history.times[(history.start+history.count)%len(history.times)] = now
+		history.count++
+	}
+	history.expiresAt = now.Add(window)
+
+	return &domain.RateLimitStatus{
+		Allowed:       true,
+		RemainingReqs: maxRequests - history.count,
+		BlockedUntil:  time.Time{},
+	}, nil
+}
+
+func (m *MemoryStorage) SlidingWindowCounter(ctx context.Context, blockKey, key string, maxRequests, cost int, window, blockDuration time.Duration) (*domain.RateLimitStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	if blockedUntil, blocked := m.blockedUntil(blockKey, now); blocked {
+		return &domain.RateLimitStatus{
+			Allowed:       false,
+			RemainingReqs: 0,
+			BlockedUntil:  blockedUntil,
+		}, nil
+	}
+
+	index := now.UnixNano() / int64(window)
+	elapsed := time.Duration(now.UnixNano() % int64(window))
+
+	counter, exists := m.windows[key]
+	if !exists {
+		counter = &windowCounter{index: index}
+		m.windows[key] = counter
+	}
+	m.touch(kindWindow, key, false)
+
+	switch counter.index {
+	case index:
+	case index - 1:
+		counter.previous, counter.current = counter.current, 0
+	default:
+		counter.previous, counter.current = 0, 0
+	}
+	counter.index = index
+	counter.expiresAt = now.Add(2*window - elapsed)
+
+	weight := 1 - float64(elapsed)/float64(window)
+	estimated := float64(counter.previous)*weight + float64(counter.current)
+
+	if estimated+float64(cost) > float64(maxRequests) {
+		blockedUntil := now.Add(slidingWindowWait(counter.previous, counter.current, maxRequests, cost, window, elapsed))
+		if blockDuration > 0 {
+			blockedUntil = now.Add(blockDuration)
+			m.setBlock(blockKey, blockedUntil)
+		}
+
+		return &domain.RateLimitStatus{
+			Allowed:       false,
+			RemainingReqs: 0,
+			BlockedUntil:  blockedUntil,
+			Tripped:       blockDuration > 0,
+		}, nil
+	}
+
+	counter.current += int64(cost)
+
+	return &domain.RateLimitStatus{
+		Allowed:       true,
+		RemainingReqs: int(float64(maxRequests) - estimated - float64(cost)),
+		BlockedUntil:  time.Time{},
+	}, nil
+}
+
+// slidingWindowWait estimates how long until the weighted count leaves room
+// for cost more units, falling back to the end of the current window.
+func slidingWindowWait(previous, current int64, maxRequests, cost int, window, elapsed time.Duration) time.Duration {
+	free := float64(maxRequests) - float64(cost) - float64(current)
+	if previous > 0 && free >= 0 {
+		wait := time.Duration(float64(window)*(1-free/float64(previous))) - elapsed
+		if wait > 0 {
+			return wait
+		}
+	}
+	return window - elapsed
+}
+
+func (m *MemoryStorage) GCRA(ctx context.Context, key string, emissionInterval, tolerance time.Duration, cost int) (*domain.RateLimitStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	tat := now
+	if state, exists := m.data[key]; exists && now.Before(state.expiresAt) {
+		tat = time.Unix(0, state.value)
+	}
+
+	newTAT := tat.Add(emissionInterval * time.Duration(cost))
+	allowAt := newTAT.Add(-tolerance)
+
+	if now.Before(allowAt) {
+		return &domain.RateLimitStatus{
+			Allowed:       false,
+			RemainingReqs: 0,
+			BlockedUntil:  allowAt,
+		}, nil
+	}
+
+	m.data[key] = &entry{
+		value:     newTAT.UnixNano(),
+		expiresAt: newTAT,
+	}
+	m.touch(kindData, key, false)
+
+	return &domain.RateLimitStatus{
+		Allowed:       true,
+		RemainingReqs: int((tolerance - newTAT.Sub(now)) / emissionInterval),
+		BlockedUntil:  time.Time{},
+	}, nil
+}
+
+func (m *MemoryStorage) blockedUntil(key string, now time.Time) (time.Time, bool) {
+	block, exists := m.data[key]
+	if !exists || block.value != 1 || !now.Before(block.expiresAt) {
+		return time.Time{}, false
+	}
+	m.touch(kindData, key, true)
+	return block.expiresAt, true
+}
+
+func (m *MemoryStorage) setBlock(key string, until time.Time) {
+	m.data[key] = &entry{
+		value:     1,
+		expiresAt: until,
+		block:     true,
+	}
+	m.touch(kindData, key, true)
+}
+
+// touch must be called with the lock held whenever key is used, and evicts
+// the least recently used keys other than key when over the bound.
+func (m *MemoryStorage) touch(kind keyKind, key string, block bool) {
+	m.lru.touch(kind, key, block)
+	m.evict(lruKey{kind: kind, key: key})
+}
+
+// evict never evicts keep, the keys in use by the caller, so a store full
+// of blocks evicts a block rather than the counter just written.
+func (m *MemoryStorage) evict(keep ...lruKey) {
+	for m.maxEntries > 0 && m.lru.len() > m.maxEntries {
+		victim, ok := m.lru.oldest(keep...)
+		if !ok {
+			return
+		}
+
+		switch victim.kind {
+		case kindData:
+			delete(m.data, victim.key)
+		case kindBucket:
+			delete(m.buckets, victim.key)
+		case kindLog:
+			delete(m.logs, victim.key)
+		case kindWindow:
+			delete(m.windows, victim.key)
+		case kindSlots:
+			delete(m.slots, victim.key)
+		}
+
+		m.evictions++
+		evictions.Add(1)
+	}
+}
+
 func (m *MemoryStorage) Close() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	m.data = make(map[string]*entry)
+	m.buckets = make(map[string]*bucket)
+	m.logs = make(map[string]*ringLog)
+	m.windows = make(map[string]*windowCounter)
+	m.slots = make(map[string]map[string]time.Time)
+	m.lru = newLRU()
 	return nil
 }
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"container/list"
+	"expvar"
+)
+
+// evictions counts the keys evicted by every MemoryStorage. It is
+// published with expvar.
+var evictions = expvar.NewInt("memory_storage_evictions")
+
+// keyKind identifies the map of MemoryStorage holding a key.
+type keyKind int
+
+const (
+	kindData keyKind = iota
+	kindBucket
+	kindLog
+	kindWindow
+	kindSlots
+)
+
+type lruKey struct {
+	kind keyKind
+	key  string
+}
+
+type lruEntry struct {
+	lruKey
+	block bool
+}
+
+// lru orders the keys of a MemoryStorage by last use. Blocks are kept in
+// their own list, so they are only evicted once no other key is left.
+type lru struct {
+	recent   *list.List
+	blocks   *list.List
+	elements map[lruKey]*list.Element
+}
+
+func newLRU() *lru {
+	return &lru{
+		recent:   list.New(),
+		blocks:   list.New(),
+		elements: make(map[lruKey]*list.Element),
+	}
+}
+
+func (l *lru) len() int {
+	return len(l.elements)
+}
+
+// touch marks key as the most recently used one.
+func (l *lru) touch(kind keyKind, key string, block bool) {
+	k := lruKey{kind: kind, key: key}
+	if element, exists := l.elements[k]; exists {
+		if element.Value.(*lruEntry).block == block {
+			l.listOf(block).MoveToFront(element)
+			return
+		}
+		l.listOf(!block).Remove(element)
+	}
+	l.elements[k] = l.listOf(block).PushFront(&lruEntry{lruKey: k, block: block})
+}
+
+func (l *lru) forget(kind keyKind, key string) {
+	k := lruKey{kind: kind, key: key}
+	if element, exists := l.elements[k]; exists {
+		l.listOf(element.Value.(*lruEntry).block).Remove(element)
+		delete(l.elements, k)
+	}
+}
+
+// oldest removes and returns the least recently used key other than keep,
+// preferring keys that are not blocks.
+func (l *lru) oldest(keep ...lruKey) (lruKey, bool) {
+	for _, candidates := range []*list.List{l.recent, l.blocks} {
+		for element := candidates.Back(); element != nil; element = element.Prev() {
+			entry := element.Value.(*lruEntry)
+			if kept(entry.lruKey, keep) {
+				continue
+			}
+
+			candidates.Remove(element)
+			delete(l.elements, entry.lruKey)
+			return entry.lruKey, true
+		}
+	}
+	return lruKey{}, false
+}
+
+func kept(key lruKey, keep []lruKey) bool {
+	for _, k := range keep {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *lru) listOf(block bool) *list.List {
+	if block {
+		return l.blocks
+	}
+	return l.recent
+}
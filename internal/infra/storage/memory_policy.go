@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
+)
+
+// watchBuffer is how many changes a watcher may fall behind before further
+// ones are dropped.
+const watchBuffer = 64
+
+// MemoryPolicyStore is the in-process PolicyStore, for a single instance.
+type MemoryPolicyStore struct {
+	mu       sync.RWMutex
+	policies map[string]domain.TokenPolicy
+	watchers map[chan string]struct{}
+}
+
+func NewMemoryPolicyStore() *MemoryPolicyStore {
+	return &MemoryPolicyStore{
+		policies: make(map[string]domain.TokenPolicy),
+		watchers: make(map[chan string]struct{}),
+	}
+}
+
+func (m *MemoryPolicyStore) SetPolicy(ctx context.Context, token string, policy domain.TokenPolicy) error {
+	if err := validatePolicy(policy); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.policies[token] = policy
+	m.notify(token)
+	return nil
+}
+
+func (m *MemoryPolicyStore) RemovePolicy(ctx context.Context, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.policies, token)
+	m.notify(token)
+	return nil
+}
+
+func (m *MemoryPolicyStore) Policy(ctx context.Context, token string) (domain.TokenPolicy, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	policy, exists := m.policies[token]
+	return policy, exists, nil
+}
+
+func (m *MemoryPolicyStore) Policies(ctx context.Context) (map[string]domain.TokenPolicy, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	policies := make(map[string]domain.TokenPolicy, len(m.policies))
+	for token, policy := range m.policies {
+		policies[token] = policy
+	}
+	return policies, nil
+}
+
+func (m *MemoryPolicyStore) Watch(ctx context.Context) (<-chan string, error) {
+	changes := make(chan string, watchBuffer)
+
+	m.mu.Lock()
+	m.watchers[changes] = struct{}{}
+	m.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		delete(m.watchers, changes)
+		close(changes)
+	}()
+
+	return changes, nil
+}
+
+// notify must be called with the lock held. It never waits for a watcher,
+// so a stalled one misses changes instead of blocking writes.
+func (m *MemoryPolicyStore) notify(token string) {
+	for changes := range m.watchers {
+		select {
+		case changes <- token:
+		default:
+		}
+	}
+}
+
+func validatePolicy(policy domain.TokenPolicy) error {
+	if policy.MaxRequests <= 0 {
+		return fmt.Errorf("policy limit must be positive, got %d", policy.MaxRequests)
+	}
+	if policy.Window < 0 || policy.BlockDuration < 0 || policy.Burst < 0 {
+		return fmt.Errorf("policy window, block duration and burst must not be negative")
+	}
+	switch policy.Strategy {
+	case "", domain.StrategyFixedWindow, domain.StrategyTokenBucket, domain.StrategySlidingLog, domain.StrategySlidingWindow, domain.StrategyGCRA:
+	default:
+		return fmt.Errorf("invalid policy strategy %q", policy.Strategy)
+	}
+	return nil
+}
@@ -5,37 +5,296 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
 	"github.com/go-redis/redis/v8"
 )
 
+// checkAndBlockScript takes the block key followed by one key per window
+// counter, with ARGV holding the block duration and the request cost
+// followed by (max_requests, window_ms, no_block) per counter. It returns
+// {allowed, remaining, blocked_ms, window_index, reset_ms, tripped}.
+var checkAndBlockScript = redis.NewScript(`
+local block_ttl = redis.call('PTTL', KEYS[1])
+if block_ttl > 0 then
+	return {0, 0, block_ttl, -1, block_ttl, 0}
+end
+
+local block_ms = tonumber(ARGV[1])
+local cost = tonumber(ARGV[2])
+
+for i = 2, #KEYS do
+	local max_requests = tonumber(ARGV[i * 3 - 3])
+	local window_ms = tonumber(ARGV[i * 3 - 2])
+	local no_block = ARGV[i * 3 - 1] == '1'
+	local count = tonumber(redis.call('GET', KEYS[i])) or 0
+
+	if count + cost > max_requests then
+		local reset_ms = redis.call('PTTL', KEYS[i])
+		if reset_ms < 0 then
+			reset_ms = window_ms
+		end
+		if block_ms > 0 and not no_block then
+			redis.call('SET', KEYS[1], '1', 'PX', block_ms)
+			return {0, 0, block_ms, i - 2, reset_ms, 1}
+		end
+		return {0, 0, reset_ms, i - 2, reset_ms, 0}
+	end
+end
+
+local remaining = -1
+local tightest = 0
+local reset_ms = 0
+
+for i = 2, #KEYS do
+	local max_requests = tonumber(ARGV[i * 3 - 3])
+	local window_ms = tonumber(ARGV[i * 3 - 2])
+
+	local count = redis.call('INCRBY', KEYS[i], cost)
+	if redis.call('PTTL', KEYS[i]) < 0 then
+		redis.call('PEXPIRE', KEYS[i], window_ms)
+	end
+
+	if remaining < 0 or max_requests - count < remaining then
+		remaining = max_requests - count
+		tightest = i - 2
+		reset_ms = redis.call('PTTL', KEYS[i])
+	end
+end
+
+return {1, remaining, 0, tightest, reset_ms, 0}
+`)
+
+// takeTokenScript returns {allowed, remaining, wait_ms}. Time is read from
+// the server so every instance refills buckets with the same clock.
+var takeTokenScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local refill_rate = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'updated_at')
+local tokens = tonumber(state[1])
+local updated_at = tonumber(state[2])
+if tokens == nil or updated_at == nil then
+	tokens = capacity
+	updated_at = now
+end
+
+tokens = math.min(capacity, tokens + math.max(0, now - updated_at) * refill_rate / 1000)
+
+local allowed = 0
+local wait_ms = 0
+if tokens >= cost then
+	tokens = tokens - cost
+	allowed = 1
+else
+	wait_ms = math.ceil((cost - tokens) * 1000 / refill_rate)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated_at', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(capacity * 1000 / refill_rate))
+
+return {allowed, math.floor(tokens), wait_ms}
+`)
+
+// slidingLogScript keeps one sorted set member per accepted unit of cost
+// and returns {allowed, remaining, blocked_ms, tripped}.
+var slidingLogScript = redis.NewScript(`
+local block_ttl = redis.call('PTTL', KEYS[1])
+if block_ttl > 0 then
+	return {0, 0, block_ttl, 0}
+end
+
+local max_requests = tonumber(ARGV[1])
+local cost = tonumber(ARGV[2])
+local window_ms = tonumber(ARGV[3])
+local block_ms = tonumber(ARGV[4])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', now - window_ms)
+local count = redis.call('ZCARD', KEYS[2])
+
+if count + cost > max_requests then
+	if block_ms > 0 then
+		redis.call('SET', KEYS[1], '1', 'PX', block_ms)
+		return {0, 0, block_ms, 1}
+	end
+	if count > 0 and cost <= max_requests then
+		local oldest = redis.call('ZRANGE', KEYS[2], count + cost - max_requests - 1, count + cost - max_requests - 1, 'WITHSCORES')
+		return {0, 0, tonumber(oldest[2]) + window_ms - now, 0}
+	end
+	return {0, 0, window_ms, 0}
+end
+
+for i = 1, cost do
+	redis.call('ZADD', KEYS[2], now, time[1] .. time[2] .. ':' .. (count + i))
+end
+redis.call('PEXPIRE', KEYS[2], window_ms)
+
+return {1, max_requests - count - cost, 0, 0}
+`)
+
+// slidingWindowScript weights the previous fixed window by the portion of it
+// still covered by the sliding window and returns {allowed, remaining,
+// blocked_ms, tripped}.
+var slidingWindowScript = redis.NewScript(`
+local block_ttl = redis.call('PTTL', KEYS[1])
+if block_ttl > 0 then
+	return {0, 0, block_ttl, 0}
+end
+
+local max_requests = tonumber(ARGV[1])
+local cost = tonumber(ARGV[2])
+local window_ms = tonumber(ARGV[3])
+local block_ms = tonumber(ARGV[4])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local index = math.floor(now / window_ms)
+local elapsed = now - index * window_ms
+
+local state = redis.call('HMGET', KEYS[2], 'index', 'current', 'previous')
+local stored_index = tonumber(state[1])
+local current = tonumber(state[2]) or 0
+local previous = tonumber(state[3]) or 0
+
+if stored_index == index - 1 then
+	previous = current
+	current = 0
+elseif stored_index ~= index then
+	previous = 0
+	current = 0
+end
+
+local estimated = previous * (1 - elapsed / window_ms) + current
+
+if estimated + cost > max_requests then
+	redis.call('HSET', KEYS[2], 'index', index, 'current', current, 'previous', previous)
+	redis.call('PEXPIRE', KEYS[2], 2 * window_ms - elapsed)
+	if block_ms > 0 then
+		redis.call('SET', KEYS[1], '1', 'PX', block_ms)
+		return {0, 0, block_ms, 1}
+	end
+	local free = max_requests - cost - current
+	if previous > 0 and free >= 0 then
+		local wait = math.ceil(window_ms * (1 - free / previous) - elapsed)
+		if wait > 0 then
+			return {0, 0, wait, 0}
+		end
+	end
+	return {0, 0, window_ms - elapsed, 0}
+end
+
+current = current + cost
+redis.call('HSET', KEYS[2], 'index', index, 'current', current, 'previous', previous)
+redis.call('PEXPIRE', KEYS[2], 2 * window_ms - elapsed)
+
+return {1, math.floor(max_requests - estimated - cost), 0, 0}
+`)
+
+// gcraScript stores the theoretical arrival time in microseconds and
+// returns {allowed, remaining, retry_after_us}. The emission interval must
+// be at least one microsecond.
+var gcraScript = redis.NewScript(`
+local emission_interval = tonumber(ARGV[1])
+local tolerance = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+local tat = tonumber(redis.call('GET', KEYS[1]))
+if tat == nil or tat < now then
+	tat = now
+end
+
+local new_tat = tat + emission_interval * cost
+local allow_at = new_tat - tolerance
+
+if now < allow_at then
+	return {0, 0, allow_at - now}
+end
+
+redis.call('SET', KEYS[1], string.format('%d', new_tat), 'PX', math.max(1, math.ceil((new_tat - now) / 1000)))
+
+return {1, math.floor((tolerance - (new_tat - now)) / emission_interval), 0}
+`)
+
+// acquireSlotScript keeps one sorted set member per lease, scored by its
+// expiration, and returns 1 when a slot was acquired.
+var acquireSlotScript = redis.NewScript(`
+local limit = tonumber(ARGV[2])
+local lease_ms = tonumber(ARGV[3])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+
+if redis.call('ZCARD', KEYS[1]) >= limit then
+	return 0
+end
+
+redis.call('ZADD', KEYS[1], now + lease_ms, ARGV[1])
+if redis.call('PTTL', KEYS[1]) < lease_ms then
+	redis.call('PEXPIRE', KEYS[1], lease_ms)
+end
+
+return 1
+`)
+
 type RedisStorage struct {
-	client *redis.Client
+	client redis.UniversalClient
 }
 
 func NewRedisStorage(host, port, password string, db int) (*RedisStorage, error) {
-	client := redis.NewClient(&redis.Options{
+	return NewRedisStorageFromClient(redis.NewClient(&redis.Options{
 		Addr:     fmt.Sprintf("%s:%s", host, port),
 		Password: password,
 		DB:       db,
-	})
+	}))
+}
 
+// NewRedisStorageFromClient uses client, which may connect to a single node,
+// to a master through Sentinel or to a Cluster. Every script keeps the keys
+// of one client in the same hash slot. The storage owns client and closes
+// it, also when connecting fails.
+func NewRedisStorageFromClient(client redis.UniversalClient) (*RedisStorage, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
 		return nil, fmt.Errorf("failed to connect to redis: %w", err)
 	}
 
+	for _, script := range []*redis.Script{checkAndBlockScript, takeTokenScript, slidingLogScript, slidingWindowScript, gcraScript, acquireSlotScript} {
+		if err := script.Load(ctx, client).Err(); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("failed to load script: %w", err)
+		}
+	}
+
 	return &RedisStorage{
 		client: client,
 	}, nil
 }
 
 func (r *RedisStorage) Increment(ctx context.Context, key string, expiration time.Duration) (int64, error) {
-	pipe := r.client.Pipeline()
+	return r.IncrementBy(ctx, key, 1, expiration)
+}
 
-	incr := pipe.Incr(ctx, key)
-	pipe.Expire(ctx, key, expiration)
+func (r *RedisStorage) IncrementBy(ctx context.Context, key string, value int64, expiration time.Duration) (int64, error) {
+	pipe := r.client.TxPipeline()
+
+	// Only the first increment of a window sets the expiration, otherwise
+	// steady traffic would keep long windows from ever resetting.
+	pipe.SetNX(ctx, key, 0, expiration)
+	incr := pipe.IncrBy(ctx, key, value)
 
 	if _, err := pipe.Exec(ctx); err != nil {
 		return 0, fmt.Errorf("failed to increment and set expiration: %w", err)
@@ -44,6 +303,19 @@ func (r *RedisStorage) Increment(ctx context.Context, key string, expiration tim
 	return incr.Val(), nil
 }
 
+func (r *RedisStorage) RecordOffense(ctx context.Context, key string, memory time.Duration) (int64, error) {
+	pipe := r.client.TxPipeline()
+
+	incr := pipe.Incr(ctx, key)
+	pipe.PExpire(ctx, key, memory)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to record offense: %w", err)
+	}
+
+	return incr.Val(), nil
+}
+
 func (r *RedisStorage) Get(ctx context.Context, key string) (int64, error) {
 	val, err := r.client.Get(ctx, key).Int64()
 	if err == redis.Nil {
@@ -81,6 +353,129 @@ func (r *RedisStorage) GetTTL(ctx context.Context, key string) (time.Duration, e
 	return ttl, nil
 }
 
+func (r *RedisStorage) AcquireSlot(ctx context.Context, key, leaseID string, limit int, lease time.Duration) (bool, error) {
+	acquired, err := acquireSlotScript.Run(ctx, r.client, []string{key}, leaseID, limit, lease.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire slot: %w", err)
+	}
+	return acquired == 1, nil
+}
+
+func (r *RedisStorage) ReleaseSlot(ctx context.Context, key, leaseID string) error {
+	if err := r.client.ZRem(ctx, key, leaseID).Err(); err != nil {
+		return fmt.Errorf("failed to release slot: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisStorage) CheckAndBlock(ctx context.Context, blockKey string, counters []domain.WindowCounter, cost int, blockDuration time.Duration) (*domain.RateLimitStatus, error) {
+	keys := make([]string, 0, len(counters)+1)
+	args := make([]interface{}, 0, len(counters)*3+2)
+
+	keys = append(keys, blockKey)
+	args = append(args, blockDuration.Milliseconds(), cost)
+	for _, counter := range counters {
+		noBlock := 0
+		if counter.NoBlock {
+			noBlock = 1
+		}
+		keys = append(keys, counter.Key)
+		args = append(args, counter.MaxRequests, counter.Window.Milliseconds(), noBlock)
+	}
+
+	res, err := checkAndBlockScript.Run(ctx, r.client, keys, args...).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run check script: %w", err)
+	}
+
+	now := time.Now()
+	status := &domain.RateLimitStatus{
+		Allowed:       res[0] == 1,
+		RemainingReqs: int(res[1]),
+		ResetAt:       now.Add(time.Duration(res[4]) * time.Millisecond),
+	}
+	if !status.Allowed {
+		status.BlockedUntil = now.Add(time.Duration(res[2]) * time.Millisecond)
+		status.Tripped = res[5] == 1
+	}
+	if res[3] >= 0 && int(res[3]) < len(counters) {
+		status.Window = counters[res[3]].Window
+		status.Limit = counters[res[3]].MaxRequests
+	}
+
+	return status, nil
+}
+
+func (r *RedisStorage) TakeToken(ctx context.Context, key string, capacity int, refillRate float64, cost int) (*domain.RateLimitStatus, error) {
+	res, err := takeTokenScript.Run(ctx, r.client, []string{key}, capacity, refillRate, cost).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run token bucket script: %w", err)
+	}
+
+	status := &domain.RateLimitStatus{
+		Allowed:       res[0] == 1,
+		RemainingReqs: int(res[1]),
+	}
+	if !status.Allowed {
+		status.BlockedUntil = time.Now().Add(time.Duration(res[2]) * time.Millisecond)
+	}
+
+	return status, nil
+}
+
+func (r *RedisStorage) SlidingLog(ctx context.Context, blockKey, key string, maxRequests, cost int, window, blockDuration time.Duration) (*domain.RateLimitStatus, error) {
+	return r.runBlockingScript(ctx, slidingLogScript, blockKey, key, maxRequests, cost, window, blockDuration)
+}
+
+func (r *RedisStorage) SlidingWindowCounter(ctx context.Context, blockKey, key string, maxRequests, cost int, window, blockDuration time.Duration) (*domain.RateLimitStatus, error) {
+	return r.runBlockingScript(ctx, slidingWindowScript, blockKey, key, maxRequests, cost, window, blockDuration)
+}
+
+func (r *RedisStorage) GCRA(ctx context.Context, key string, emissionInterval, tolerance time.Duration, cost int) (*domain.RateLimitStatus, error) {
+	res, err := gcraScript.Run(ctx, r.client, []string{key}, ceilMicroseconds(emissionInterval), tolerance.Microseconds(), cost).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run gcra script: %w", err)
+	}
+
+	status := &domain.RateLimitStatus{
+		Allowed:       res[0] == 1,
+		RemainingReqs: int(res[1]),
+	}
+	if !status.Allowed {
+		status.BlockedUntil = time.Now().Add(time.Duration(res[2]) * time.Microsecond)
+	}
+
+	return status, nil
+}
+
+// ceilMicroseconds rounds d up to whole microseconds, the resolution of
+// gcraScript, so emission intervals under one microsecond, i.e. over a
+// million requests per second, do not truncate to zero.
+func ceilMicroseconds(d time.Duration) int64 {
+	return int64((d + time.Microsecond - 1) / time.Microsecond)
+}
+
+func (r *RedisStorage) runBlockingScript(ctx context.Context, script *redis.Script, blockKey, key string, maxRequests, cost int, window, blockDuration time.Duration) (*domain.RateLimitStatus, error) {
+	res, err := script.Run(ctx, r.client,
+		[]string{blockKey, key},
+		maxRequests, cost, window.Milliseconds(), blockDuration.Milliseconds(),
+	).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run script: %w", err)
+	}
+
+	status := &domain.RateLimitStatus{
+		Allowed:       res[0] == 1,
+		RemainingReqs: int(res[1]),
+	}
+	if !status.Allowed {
+		status.BlockedUntil = time.Now().Add(time.Duration(res[2]) * time.Millisecond)
+		status.Tripped = res[3] == 1
+	}
+
+	return status, nil
+}
+
 func (r *RedisStorage) Close() error {
 	return r.client.Close()
 }
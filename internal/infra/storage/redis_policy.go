@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// policiesKey is the hash of token to policy.
+	policiesKey = "policies:token"
+	// policiesChannel receives the token of every policy changed.
+	policiesChannel = "policies:token:changed"
+)
+
+// storedPolicy is how a policy is kept in the hash, with the keys of the
+// policy file, e.g. {"limit": 1000, "window": "1m", "block_duration": "5m"}.
+type storedPolicy struct {
+	Limit         int    `json:"limit"`
+	Window        string `json:"window,omitempty"`
+	BlockDuration string `json:"block_duration,omitempty"`
+	Strategy      string `json:"strategy,omitempty"`
+	Burst         int    `json:"burst,omitempty"`
+}
+
+// RedisPolicyStore shares the token policies between every instance using
+// the same Redis. Changes are announced on a pub/sub channel.
+type RedisPolicyStore struct {
+	client redis.UniversalClient
+}
+
+// NewRedisPolicyStore returns a policy store using the connection of store.
+func NewRedisPolicyStore(store *RedisStorage) *RedisPolicyStore {
+	return &RedisPolicyStore{
+		client: store.client,
+	}
+}
+
+func (r *RedisPolicyStore) SetPolicy(ctx context.Context, token string, policy domain.TokenPolicy) error {
+	if err := validatePolicy(policy); err != nil {
+		return err
+	}
+
+	data, err := encodePolicy(policy)
+	if err != nil {
+		return err
+	}
+
+	if err := r.client.HSet(ctx, policiesKey, token, data).Err(); err != nil {
+		return fmt.Errorf("failed to set policy: %w", err)
+	}
+
+	return r.publish(ctx, token)
+}
+
+func (r *RedisPolicyStore) RemovePolicy(ctx context.Context, token string) error {
+	if err := r.client.HDel(ctx, policiesKey, token).Err(); err != nil {
+		return fmt.Errorf("failed to remove policy: %w", err)
+	}
+
+	return r.publish(ctx, token)
+}
+
+// publish is only called once the change is stored, as in a Cluster the
+// hash and the channel are not served by the same transaction.
+func (r *RedisPolicyStore) publish(ctx context.Context, token string) error {
+	if err := r.client.Publish(ctx, policiesChannel, token).Err(); err != nil {
+		return fmt.Errorf("failed to publish policy change: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisPolicyStore) Policy(ctx context.Context, token string) (domain.TokenPolicy, bool, error) {
+	data, err := r.client.HGet(ctx, policiesKey, token).Result()
+	if err == redis.Nil {
+		return domain.TokenPolicy{}, false, nil
+	}
+	if err != nil {
+		return domain.TokenPolicy{}, false, fmt.Errorf("failed to get policy: %w", err)
+	}
+
+	policy, err := decodePolicy(data)
+	if err != nil {
+		return domain.TokenPolicy{}, false, fmt.Errorf("invalid policy of token %s: %w", token, err)
+	}
+
+	return policy, true, nil
+}
+
+func (r *RedisPolicyStore) Policies(ctx context.Context) (map[string]domain.TokenPolicy, error) {
+	entries, err := r.client.HGetAll(ctx, policiesKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get policies: %w", err)
+	}
+
+	policies := make(map[string]domain.TokenPolicy, len(entries))
+	for token, data := range entries {
+		policy, err := decodePolicy(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid policy of token %s: %w", token, err)
+		}
+		policies[token] = policy
+	}
+
+	return policies, nil
+}
+
+// Watch subscribes before returning, so no change made afterwards is
+// missed unless the connection drops.
+func (r *RedisPolicyStore) Watch(ctx context.Context) (<-chan string, error) {
+	pubsub := r.client.Subscribe(ctx, policiesChannel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, fmt.Errorf("failed to subscribe to policy changes: %w", err)
+	}
+
+	changes := make(chan string, watchBuffer)
+	go func() {
+		defer close(changes)
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case message, ok := <-messages:
+				if !ok {
+					return
+				}
+				select {
+				case changes <- message.Payload:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return changes, nil
+}
+
+func encodePolicy(policy domain.TokenPolicy) (string, error) {
+	stored := storedPolicy{
+		Limit:    policy.MaxRequests,
+		Strategy: string(policy.Strategy),
+		Burst:    policy.Burst,
+	}
+	if policy.Window > 0 {
+		stored.Window = policy.Window.String()
+	}
+	if policy.BlockDuration > 0 {
+		stored.BlockDuration = policy.BlockDuration.String()
+	}
+
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode policy: %w", err)
+	}
+	return string(data), nil
+}
+
+func decodePolicy(data string) (domain.TokenPolicy, error) {
+	var stored storedPolicy
+	if err := json.Unmarshal([]byte(data), &stored); err != nil {
+		return domain.TokenPolicy{}, err
+	}
+
+	policy := domain.TokenPolicy{
+		MaxRequests: stored.Limit,
+		Strategy:    domain.Strategy(stored.Strategy),
+		Burst:       stored.Burst,
+	}
+
+	var err error
+	if stored.Window != "" {
+		if policy.Window, err = time.ParseDuration(stored.Window); err != nil {
+			return domain.TokenPolicy{}, err
+		}
+	}
+	if stored.BlockDuration != "" {
+		if policy.BlockDuration, err = time.ParseDuration(stored.BlockDuration); err != nil {
+			return domain.TokenPolicy{}, err
+		}
+	}
+
+	return policy, validatePolicy(policy)
+}
@@ -2,9 +2,13 @@ package storage
 
 import (
 	"context"
+	"os"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
+	"github.com/go-redis/redis/v8"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -113,3 +117,828 @@ func TestMemoryStorage_Expiration(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, int64(0), val)
 }
+
+func TestMemoryStorage_CheckAndBlock(t *testing.T) {
+	store := NewMemoryStorage()
+	defer store.Close()
+
+	ctx := context.Background()
+	counters := []domain.WindowCounter{{Key: "count:test", MaxRequests: 3, Window: time.Second}}
+
+	for i := 0; i < 3; i++ {
+		status, err := store.CheckAndBlock(ctx, "block:test", counters, 1, 2*time.Second)
+		require.NoError(t, err)
+		assert.True(t, status.Allowed)
+		assert.Equal(t, 2-i, status.RemainingReqs)
+	}
+
+	status, err := store.CheckAndBlock(ctx, "block:test", counters, 1, 2*time.Second)
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+	assert.WithinDuration(t, time.Now().Add(2*time.Second), status.BlockedUntil, 100*time.Millisecond)
+
+	blocked, err := store.IsBlocked(ctx, "block:test")
+	require.NoError(t, err)
+	assert.True(t, blocked)
+}
+
+func TestMemoryStorage_CheckAndBlockMultipleWindows(t *testing.T) {
+	store := NewMemoryStorage()
+	defer store.Close()
+
+	ctx := context.Background()
+	counters := []domain.WindowCounter{
+		{Key: "count:test:1s", MaxRequests: 5, Window: time.Second},
+		{Key: "count:test:1m", MaxRequests: 3, Window: time.Minute},
+	}
+
+	for i := 0; i < 3; i++ {
+		status, err := store.CheckAndBlock(ctx, "block:test", counters, 1, 0)
+		require.NoError(t, err)
+		assert.True(t, status.Allowed)
+		assert.Equal(t, time.Minute, status.Window)
+		assert.Equal(t, 3, status.Limit)
+	}
+
+	status, err := store.CheckAndBlock(ctx, "block:test", counters, 1, 0)
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+	assert.Equal(t, time.Minute, status.Window)
+	assert.Equal(t, 3, status.Limit)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), status.ResetAt, time.Second)
+
+	count, err := store.Get(ctx, "count:test:1s")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	blocked, err := store.IsBlocked(ctx, "block:test")
+	require.NoError(t, err)
+	assert.False(t, blocked)
+}
+
+func TestMemoryStorage_TakeToken(t *testing.T) {
+	store := NewMemoryStorage()
+	defer store.Close()
+
+	ctx := context.Background()
+	key := "bucket:test"
+
+	for i := 0; i < 3; i++ {
+		status, err := store.TakeToken(ctx, key, 3, 10, 1)
+		require.NoError(t, err)
+		assert.True(t, status.Allowed)
+	}
+
+	status, err := store.TakeToken(ctx, key, 3, 10, 1)
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+	assert.WithinDuration(t, time.Now().Add(100*time.Millisecond), status.BlockedUntil, 20*time.Millisecond)
+
+	time.Sleep(150 * time.Millisecond)
+
+	status, err = store.TakeToken(ctx, key, 3, 10, 1)
+	require.NoError(t, err)
+	assert.True(t, status.Allowed)
+}
+
+func TestMemoryStorage_SlidingLog(t *testing.T) {
+	store := NewMemoryStorage()
+	defer store.Close()
+
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		status, err := store.SlidingLog(ctx, "block:log", "log:test", 3, 1, 500*time.Millisecond, 0)
+		require.NoError(t, err)
+		assert.True(t, status.Allowed)
+		assert.Equal(t, 2-i, status.RemainingReqs)
+	}
+
+	status, err := store.SlidingLog(ctx, "block:log", "log:test", 3, 1, 500*time.Millisecond, 0)
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+
+	blocked, err := store.IsBlocked(ctx, "block:log")
+	require.NoError(t, err)
+	assert.False(t, blocked)
+
+	time.Sleep(600 * time.Millisecond)
+
+	status, err = store.SlidingLog(ctx, "block:log", "log:test", 3, 1, 500*time.Millisecond, 0)
+	require.NoError(t, err)
+	assert.True(t, status.Allowed)
+}
+
+func TestMemoryStorage_SlidingLogGrowsLazily(t *testing.T) {
+	store := NewMemoryStorage()
+	defer store.Close()
+
+	ctx := context.Background()
+
+	status, err := store.SlidingLog(ctx, "block:log", "log:large", 10000, 1, 24*time.Hour, 0)
+	require.NoError(t, err)
+	assert.True(t, status.Allowed)
+	assert.Equal(t, 9999, status.RemainingReqs)
+	assert.Len(t, store.logs["log:large"].times, 1)
+
+	for i := 0; i < 4; i++ {
+		status, err = store.SlidingLog(ctx, "block:log", "log:small", 5, 1, time.Minute, 0)
+		require.NoError(t, err)
+		assert.True(t, status.Allowed)
+	}
+	status, err = store.SlidingLog(ctx, "block:log", "log:small", 5, 2, time.Minute, 0)
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+
+	status, err = store.SlidingLog(ctx, "block:log", "log:small", 5, 1, time.Minute, 0)
+	require.NoError(t, err)
+	assert.True(t, status.Allowed)
+	assert.Equal(t, 0, status.RemainingReqs)
+	assert.Len(t, store.logs["log:small"].times, 5)
+}
+
+func TestSlidingLog_LimitChangeKeepsLog(t *testing.T) {
+	memory := NewMemoryStorage()
+	defer memory.Close()
+
+	for name, store := range map[string]domain.SlidingWindowStorage{
+		"memory": memory,
+		"redis":  newTestRedis(t),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			for i := 0; i < 10; i++ {
+				status, err := store.SlidingLog(ctx, "block:{ip:1}", "log:{ip:1}", 10, 1, time.Minute, 0)
+				require.NoError(t, err)
+				assert.True(t, status.Allowed)
+			}
+
+			// Lowering the limit keeps the ten requests in the window.
+			status, err := store.SlidingLog(ctx, "block:{ip:1}", "log:{ip:1}", 9, 1, time.Minute, 0)
+			require.NoError(t, err)
+			assert.False(t, status.Allowed)
+
+			// Raising it again leaves room for exactly two more.
+			for i := 0; i < 2; i++ {
+				status, err = store.SlidingLog(ctx, "block:{ip:1}", "log:{ip:1}", 12, 1, time.Minute, 0)
+				require.NoError(t, err)
+				assert.True(t, status.Allowed)
+				assert.Equal(t, 1-i, status.RemainingReqs)
+			}
+
+			status, err = store.SlidingLog(ctx, "block:{ip:1}", "log:{ip:1}", 12, 1, time.Minute, 0)
+			require.NoError(t, err)
+			assert.False(t, status.Allowed)
+		})
+	}
+}
+
+func TestMemoryStorage_SlidingWindowCounter(t *testing.T) {
+	store := NewMemoryStorage()
+	defer store.Close()
+
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		status, err := store.SlidingWindowCounter(ctx, "block:window", "window:test", 3, 1, time.Minute, time.Second)
+		require.NoError(t, err)
+		assert.True(t, status.Allowed)
+	}
+
+	status, err := store.SlidingWindowCounter(ctx, "block:window", "window:test", 3, 1, time.Minute, time.Second)
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+
+	blocked, err := store.IsBlocked(ctx, "block:window")
+	require.NoError(t, err)
+	assert.True(t, blocked)
+}
+
+func TestMemoryStorage_GCRA(t *testing.T) {
+	store := NewMemoryStorage()
+	defer store.Close()
+
+	ctx := context.Background()
+	key := "gcra:test"
+	emissionInterval := 100 * time.Millisecond
+
+	for i := 0; i < 3; i++ {
+		status, err := store.GCRA(ctx, key, emissionInterval, 3*emissionInterval, 1)
+		require.NoError(t, err)
+		assert.True(t, status.Allowed)
+		assert.Equal(t, 2-i, status.RemainingReqs)
+	}
+
+	status, err := store.GCRA(ctx, key, emissionInterval, 3*emissionInterval, 1)
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+	assert.WithinDuration(t, time.Now().Add(emissionInterval), status.BlockedUntil, 20*time.Millisecond)
+
+	time.Sleep(emissionInterval)
+
+	status, err = store.GCRA(ctx, key, emissionInterval, 3*emissionInterval, 1)
+	require.NoError(t, err)
+	assert.True(t, status.Allowed)
+}
+
+func TestMemoryStorage_AcquireSlot(t *testing.T) {
+	store := NewMemoryStorage()
+	defer store.Close()
+
+	ctx := context.Background()
+	key := "slots:test"
+
+	acquired, err := store.AcquireSlot(ctx, key, "a", 2, time.Minute)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+
+	acquired, err = store.AcquireSlot(ctx, key, "b", 2, 500*time.Millisecond)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+
+	acquired, err = store.AcquireSlot(ctx, key, "c", 2, time.Minute)
+	require.NoError(t, err)
+	assert.False(t, acquired)
+
+	require.NoError(t, store.ReleaseSlot(ctx, key, "a"))
+
+	acquired, err = store.AcquireSlot(ctx, key, "c", 2, time.Minute)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+
+	time.Sleep(600 * time.Millisecond)
+
+	acquired, err = store.AcquireSlot(ctx, key, "d", 2, time.Minute)
+	require.NoError(t, err)
+	assert.True(t, acquired, "expired lease must be reclaimed")
+}
+
+func TestMemoryStorage_IncrementBy(t *testing.T) {
+	store := NewMemoryStorage()
+	defer store.Close()
+
+	ctx := context.Background()
+	key := "test:weighted"
+
+	val, err := store.IncrementBy(ctx, key, 50, 5*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, int64(50), val)
+
+	val, err = store.Increment(ctx, key, 5*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, int64(51), val)
+}
+
+func TestMemoryStorage_RecordOffense(t *testing.T) {
+	store := NewMemoryStorage()
+	defer store.Close()
+
+	ctx := context.Background()
+	key := "offenses:ip:10.0.0.1"
+
+	for i := int64(1); i <= 3; i++ {
+		offenses, err := store.RecordOffense(ctx, key, 100*time.Millisecond)
+		require.NoError(t, err)
+		assert.Equal(t, i, offenses)
+		time.Sleep(60 * time.Millisecond)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	offenses, err := store.RecordOffense(ctx, key, 100*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), offenses)
+}
+
+func TestMemoryPolicyStore(t *testing.T) {
+	store := NewMemoryPolicyStore()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes, err := store.Watch(ctx)
+	require.NoError(t, err)
+
+	policy := domain.TokenPolicy{MaxRequests: 100, Window: time.Minute}
+	require.NoError(t, store.SetPolicy(ctx, "premium-token", policy))
+	assert.Equal(t, "premium-token", <-changes)
+
+	stored, exists, err := store.Policy(ctx, "premium-token")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, policy, stored)
+
+	assert.Error(t, store.SetPolicy(ctx, "invalid-token", domain.TokenPolicy{}))
+
+	require.NoError(t, store.RemovePolicy(ctx, "premium-token"))
+	assert.Equal(t, "premium-token", <-changes)
+
+	policies, err := store.Policies(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, policies)
+
+	cancel()
+	_, open := <-changes
+	assert.False(t, open)
+}
+
+func TestRedisPolicyStore(t *testing.T) {
+	redisStore := newTestRedis(t)
+	store := NewRedisPolicyStore(redisStore.RedisStorage)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes, err := store.Watch(ctx)
+	require.NoError(t, err)
+
+	policy := domain.TokenPolicy{MaxRequests: 100, Window: time.Minute, Strategy: domain.StrategyGCRA}
+	require.NoError(t, store.SetPolicy(ctx, "premium-token", policy))
+	assert.Equal(t, "premium-token", <-changes)
+
+	stored, exists, err := store.Policy(ctx, "premium-token")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, policy, stored)
+
+	policies, err := store.Policies(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]domain.TokenPolicy{"premium-token": policy}, policies)
+
+	assert.Error(t, store.SetPolicy(ctx, "invalid-token", domain.TokenPolicy{}))
+	assert.Error(t, store.SetPolicy(ctx, "invalid-token", domain.TokenPolicy{MaxRequests: 1, Strategy: domain.StrategyConcurrency}))
+
+	require.NoError(t, store.RemovePolicy(ctx, "premium-token"))
+	assert.Equal(t, "premium-token", <-changes)
+
+	_, exists, err = store.Policy(ctx, "premium-token")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	policies, err = store.Policies(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, policies)
+
+	// A policy written to the hash by hand is validated when read.
+	require.NoError(t, redisStore.client.HSet(ctx, policiesKey, "bogus-token", `{"limit": 1, "strategy": "bogus"}`).Err())
+	_, _, err = store.Policy(ctx, "bogus-token")
+	assert.Error(t, err)
+
+	cancel()
+	for range changes {
+	}
+}
+
+func TestDecodePolicy(t *testing.T) {
+	policy := domain.TokenPolicy{
+		MaxRequests:   10,
+		Window:        time.Minute,
+		BlockDuration: 5 * time.Minute,
+		Strategy:      domain.StrategyTokenBucket,
+		Burst:         20,
+	}
+
+	data, err := encodePolicy(policy)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"limit": 10, "window": "1m0s", "block_duration": "5m0s", "strategy": "token_bucket", "burst": 20}`, data)
+
+	decoded, err := decodePolicy(data)
+	require.NoError(t, err)
+	assert.Equal(t, policy, decoded)
+
+	decoded, err = decodePolicy(`{"limit": 1000}`)
+	require.NoError(t, err)
+	assert.Equal(t, domain.TokenPolicy{MaxRequests: 1000}, decoded)
+
+	for _, data := range []string{`{"limit": 0}`, `{"limit": 1, "window": "soon"}`, `{"limit": 1, "strategy": "bogus"}`, `{"limit": 1, "strategy": "concurrency"}`, `limit`} {
+		_, err := decodePolicy(data)
+		assert.Error(t, err, data)
+	}
+}
+
+func TestMemoryStorage_MaxEntries(t *testing.T) {
+	store := NewMemoryStorage()
+	defer store.Close()
+
+	store.SetMaxEntries(3)
+
+	ctx := context.Background()
+
+	require.NoError(t, store.SetBlock(ctx, "block:attacker", time.Minute))
+	store.Increment(ctx, "count:1", time.Minute)
+	store.Increment(ctx, "count:2", time.Minute)
+
+	// count:1 is used again, so count:2 is now the least recently used.
+	store.Increment(ctx, "count:1", time.Minute)
+	store.Increment(ctx, "count:3", time.Minute)
+	store.Increment(ctx, "count:4", time.Minute)
+
+	blocked, err := store.IsBlocked(ctx, "block:attacker")
+	require.NoError(t, err)
+	assert.True(t, blocked)
+
+	for key, expected := range map[string]int64{"count:1": 0, "count:2": 0, "count:3": 1, "count:4": 1} {
+		value, err := store.Get(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, expected, value, key)
+	}
+	assert.Equal(t, int64(2), store.Evictions())
+
+	_, err = store.TakeToken(ctx, "bucket:1", 10, 1, 1)
+	require.NoError(t, err)
+	assert.Len(t, store.buckets, 1)
+	assert.Equal(t, 3, store.lru.len())
+}
+
+func TestMemoryStorage_EvictsBlocksLast(t *testing.T) {
+	store := NewMemoryStorage()
+	defer store.Close()
+
+	ctx := context.Background()
+
+	for _, key := range []string{"block:1", "block:2", "block:3"} {
+		require.NoError(t, store.SetBlock(ctx, key, time.Minute))
+	}
+	store.Increment(ctx, "count:1", time.Minute)
+
+	store.SetMaxEntries(2)
+
+	for key, expected := range map[string]bool{"block:1": false, "block:2": true, "block:3": true} {
+		blocked, err := store.IsBlocked(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, expected, blocked, key)
+	}
+
+	value, err := store.Get(ctx, "count:1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), value)
+	assert.Equal(t, int64(2), store.Evictions())
+}
+
+func TestMemoryStorage_BlocksFillingBoundDoNotEvictNewCounters(t *testing.T) {
+	store := NewMemoryStorage()
+	defer store.Close()
+
+	store.SetMaxEntries(2)
+
+	ctx := context.Background()
+
+	require.NoError(t, store.SetBlock(ctx, "block:1", time.Minute))
+	require.NoError(t, store.SetBlock(ctx, "block:2", time.Minute))
+
+	counters := []domain.WindowCounter{
+		{Key: "count:second", MaxRequests: 3, Window: time.Minute},
+		{Key: "count:minute", MaxRequests: 5, Window: time.Minute},
+	}
+	for i := 0; i < 3; i++ {
+		status, err := store.CheckAndBlock(ctx, "block:client", counters, 1, 0)
+		require.NoError(t, err)
+		assert.True(t, status.Allowed, "request %d", i+1)
+		assert.Equal(t, 2-i, status.RemainingReqs, "request %d", i+1)
+	}
+
+	status, err := store.CheckAndBlock(ctx, "block:client", counters, 1, 0)
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+	assert.Equal(t, 2, store.lru.len())
+}
+
+// testRedis is a RedisStorage for the tests of the Lua scripts, with
+// advance moving its clock forward.
+type testRedis struct {
+	*RedisStorage
+	advance func(d time.Duration)
+}
+
+// newTestRedis connects to the Redis at REDIS_TEST_ADDR, skipping the test
+// when it is unreachable, or starts an embedded one. The embedded clock
+// only moves on advance, so scripts reading TIME see a fixed instant.
+func newTestRedis(t *testing.T) testRedis {
+	t.Helper()
+
+	if addr := os.Getenv("REDIS_TEST_ADDR"); addr != "" {
+		client := redis.NewClient(&redis.Options{Addr: addr})
+		store, err := NewRedisStorageFromClient(client)
+		if err != nil {
+			t.Skipf("Redis unavailable at %s: %v", addr, err)
+		}
+		require.NoError(t, client.FlushDB(context.Background()).Err())
+		t.Cleanup(func() { store.Close() })
+
+		return testRedis{RedisStorage: store, advance: time.Sleep}
+	}
+
+	server := miniredis.RunT(t)
+	now := time.Unix(1700000000, 0)
+	server.SetTime(now)
+
+	store, err := NewRedisStorage(server.Host(), server.Port(), "", 0)
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+
+	return testRedis{
+		RedisStorage: store,
+		advance: func(d time.Duration) {
+			now = now.Add(d)
+			server.SetTime(now)
+			server.FastForward(d)
+		},
+	}
+}
+
+func TestRedisStorage_CheckAndBlock(t *testing.T) {
+	store := newTestRedis(t)
+	ctx := context.Background()
+
+	counters := []domain.WindowCounter{
+		{Key: "count:{ip:1}:100ms", MaxRequests: 3, Window: 100 * time.Millisecond},
+		{Key: "count:{ip:1}:1h", MaxRequests: 5, Window: time.Hour},
+	}
+
+	for i := 0; i < 3; i++ {
+		status, err := store.CheckAndBlock(ctx, "block:{ip:1}", counters, 1, 0)
+		require.NoError(t, err)
+		assert.True(t, status.Allowed)
+		assert.Equal(t, 2-i, status.RemainingReqs)
+		assert.Equal(t, 100*time.Millisecond, status.Window)
+		assert.Equal(t, 3, status.Limit)
+	}
+
+	// Without a block duration, the request is denied until the window
+	// resets and nothing is counted.
+	status, err := store.CheckAndBlock(ctx, "block:{ip:1}", counters, 1, 0)
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+	assert.False(t, status.Tripped)
+	assert.Equal(t, 100*time.Millisecond, status.Window)
+	assert.WithinDuration(t, time.Now().Add(100*time.Millisecond), status.BlockedUntil, 100*time.Millisecond)
+
+	value, err := store.Get(ctx, "count:{ip:1}:1h")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), value)
+
+	store.advance(150 * time.Millisecond)
+
+	// The hourly window now has two requests left, which a cost of three
+	// exceeds, so it trips the block.
+	status, err = store.CheckAndBlock(ctx, "block:{ip:1}", counters, 3, time.Second)
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+	assert.True(t, status.Tripped)
+	assert.Equal(t, time.Hour, status.Window)
+	assert.Equal(t, 5, status.Limit)
+	assert.WithinDuration(t, time.Now().Add(time.Second), status.BlockedUntil, 100*time.Millisecond)
+
+	status, err = store.CheckAndBlock(ctx, "block:{ip:1}", counters, 1, time.Second)
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+	assert.False(t, status.Tripped)
+
+	store.advance(1100 * time.Millisecond)
+
+	status, err = store.CheckAndBlock(ctx, "block:{ip:1}", counters, 2, time.Second)
+	require.NoError(t, err)
+	assert.True(t, status.Allowed)
+	assert.Equal(t, 0, status.RemainingReqs)
+	assert.Equal(t, time.Hour, status.Window)
+}
+
+func TestRedisStorage_CheckAndBlockNoBlock(t *testing.T) {
+	store := newTestRedis(t)
+	ctx := context.Background()
+
+	counters := []domain.WindowCounter{
+		{Key: "count:{ip:1}", MaxRequests: 5, Window: time.Minute},
+		{Key: "quota:{ip:1}", MaxRequests: 1, Window: time.Hour, NoBlock: true},
+	}
+
+	status, err := store.CheckAndBlock(ctx, "block:{ip:1}", counters, 1, time.Minute)
+	require.NoError(t, err)
+	assert.True(t, status.Allowed)
+
+	// The quota denies until it resets, leaving the key unblocked.
+	status, err = store.CheckAndBlock(ctx, "block:{ip:1}", counters, 1, time.Minute)
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+	assert.False(t, status.Tripped)
+	assert.Equal(t, time.Hour, status.Window)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), status.BlockedUntil, 100*time.Millisecond)
+
+	blocked, err := store.IsBlocked(ctx, "block:{ip:1}")
+	require.NoError(t, err)
+	assert.False(t, blocked)
+
+	// Exceeding the other window still trips the block.
+	status, err = store.CheckAndBlock(ctx, "block:{ip:1}", counters[:1], 5, time.Minute)
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+	assert.True(t, status.Tripped)
+}
+
+func TestRedisStorage_TakeToken(t *testing.T) {
+	store := newTestRedis(t)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		status, err := store.TakeToken(ctx, "bucket:{ip:1}", 3, 10, 1)
+		require.NoError(t, err)
+		assert.True(t, status.Allowed)
+		assert.Equal(t, 2-i, status.RemainingReqs)
+	}
+
+	status, err := store.TakeToken(ctx, "bucket:{ip:1}", 3, 10, 1)
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+	assert.WithinDuration(t, time.Now().Add(100*time.Millisecond), status.BlockedUntil, 100*time.Millisecond)
+
+	store.advance(250 * time.Millisecond)
+
+	status, err = store.TakeToken(ctx, "bucket:{ip:1}", 3, 10, 2)
+	require.NoError(t, err)
+	assert.True(t, status.Allowed)
+}
+
+func TestRedisStorage_SlidingLog(t *testing.T) {
+	store := newTestRedis(t)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		status, err := store.SlidingLog(ctx, "block:{ip:1}", "log:{ip:1}", 3, 1, 200*time.Millisecond, 0)
+		require.NoError(t, err)
+		assert.True(t, status.Allowed)
+		assert.Equal(t, 2-i, status.RemainingReqs)
+	}
+
+	store.advance(100 * time.Millisecond)
+
+	status, err := store.SlidingLog(ctx, "block:{ip:1}", "log:{ip:1}", 3, 1, 200*time.Millisecond, 0)
+	require.NoError(t, err)
+	assert.True(t, status.Allowed)
+	assert.Equal(t, 0, status.RemainingReqs)
+
+	// Room for one more unit comes when the first two requests leave the
+	// window, 100ms from now.
+	status, err = store.SlidingLog(ctx, "block:{ip:1}", "log:{ip:1}", 3, 1, 200*time.Millisecond, 0)
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+	assert.False(t, status.Tripped)
+	assert.WithinDuration(t, time.Now().Add(100*time.Millisecond), status.BlockedUntil, 50*time.Millisecond)
+
+	store.advance(150 * time.Millisecond)
+
+	// Only the request made at 100ms is left in the window.
+	status, err = store.SlidingLog(ctx, "block:{ip:1}", "log:{ip:1}", 3, 2, 200*time.Millisecond, 0)
+	require.NoError(t, err)
+	assert.True(t, status.Allowed)
+	assert.Equal(t, 0, status.RemainingReqs)
+
+	status, err = store.SlidingLog(ctx, "block:{ip:1}", "log:{ip:1}", 3, 1, 200*time.Millisecond, time.Second)
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+	assert.True(t, status.Tripped)
+
+	blocked, err := store.IsBlocked(ctx, "block:{ip:1}")
+	require.NoError(t, err)
+	assert.True(t, blocked)
+}
+
+func TestRedisStorage_SlidingWindowCounter(t *testing.T) {
+	store := newTestRedis(t)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		status, err := store.SlidingWindowCounter(ctx, "block:{ip:1}", "window:{ip:1}", 3, 1, time.Hour, 0)
+		require.NoError(t, err)
+		assert.True(t, status.Allowed)
+	}
+
+	status, err := store.SlidingWindowCounter(ctx, "block:{ip:1}", "window:{ip:1}", 3, 1, time.Hour, 0)
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+	assert.False(t, status.Tripped)
+
+	blocked, err := store.IsBlocked(ctx, "block:{ip:1}")
+	require.NoError(t, err)
+	assert.False(t, blocked)
+
+	status, err = store.SlidingWindowCounter(ctx, "block:{ip:1}", "window:{ip:1}", 3, 1, time.Hour, time.Second)
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+	assert.True(t, status.Tripped)
+
+	blocked, err = store.IsBlocked(ctx, "block:{ip:1}")
+	require.NoError(t, err)
+	assert.True(t, blocked)
+
+	for i := 0; i < 3; i++ {
+		status, err = store.SlidingWindowCounter(ctx, "block:{ip:2}", "window:{ip:2}", 3, 1, 100*time.Millisecond, 0)
+		require.NoError(t, err)
+		assert.True(t, status.Allowed)
+	}
+
+	// One window later, the previous one still counts, weighted by the part
+	// of it the sliding window covers.
+	store.advance(100 * time.Millisecond)
+
+	status, err = store.SlidingWindowCounter(ctx, "block:{ip:2}", "window:{ip:2}", 3, 3, 100*time.Millisecond, 0)
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+
+	// Two windows later, neither the current nor the previous one counts.
+	store.advance(150 * time.Millisecond)
+
+	status, err = store.SlidingWindowCounter(ctx, "block:{ip:2}", "window:{ip:2}", 3, 3, 100*time.Millisecond, 0)
+	require.NoError(t, err)
+	assert.True(t, status.Allowed)
+	assert.Equal(t, 0, status.RemainingReqs)
+}
+
+func TestRedisStorage_GCRA(t *testing.T) {
+	store := newTestRedis(t)
+	ctx := context.Background()
+
+	emissionInterval := 100 * time.Millisecond
+
+	for i := 0; i < 3; i++ {
+		status, err := store.GCRA(ctx, "gcra:{ip:1}", emissionInterval, 3*emissionInterval, 1)
+		require.NoError(t, err)
+		assert.True(t, status.Allowed)
+		assert.Equal(t, 2-i, status.RemainingReqs)
+	}
+
+	status, err := store.GCRA(ctx, "gcra:{ip:1}", emissionInterval, 3*emissionInterval, 1)
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+	assert.WithinDuration(t, time.Now().Add(emissionInterval), status.BlockedUntil, emissionInterval)
+
+	store.advance(emissionInterval)
+
+	status, err = store.GCRA(ctx, "gcra:{ip:1}", emissionInterval, 3*emissionInterval, 1)
+	require.NoError(t, err)
+	assert.True(t, status.Allowed)
+	assert.Equal(t, 0, status.RemainingReqs)
+
+	// The stored arrival time expires once it is in the past.
+	store.advance(400 * time.Millisecond)
+
+	status, err = store.GCRA(ctx, "gcra:{ip:1}", emissionInterval, 3*emissionInterval, 3)
+	require.NoError(t, err)
+	assert.True(t, status.Allowed)
+	assert.Equal(t, 0, status.RemainingReqs)
+}
+
+func TestRedisStorage_GCRASubMicrosecondInterval(t *testing.T) {
+	store := newTestRedis(t)
+	ctx := context.Background()
+
+	// Two million requests per second round up to one per microsecond
+	// instead of truncating to zero, which set the key with PX 0.
+	status, err := store.GCRA(ctx, "gcra:{ip:1}", 500*time.Nanosecond, 2*time.Microsecond, 1)
+	require.NoError(t, err)
+	assert.True(t, status.Allowed)
+
+	for i := 0; i < 3; i++ {
+		_, err := store.GCRA(ctx, "gcra:{ip:1}", 500*time.Nanosecond, 2*time.Microsecond, 1)
+		require.NoError(t, err)
+	}
+}
+
+func TestRedisStorage_AcquireSlot(t *testing.T) {
+	store := newTestRedis(t)
+	ctx := context.Background()
+
+	for _, lease := range []string{"lease-1", "lease-2"} {
+		acquired, err := store.AcquireSlot(ctx, "slots:{ip:1}", lease, 2, 200*time.Millisecond)
+		require.NoError(t, err)
+		assert.True(t, acquired, lease)
+	}
+
+	acquired, err := store.AcquireSlot(ctx, "slots:{ip:1}", "lease-3", 2, 200*time.Millisecond)
+	require.NoError(t, err)
+	assert.False(t, acquired)
+
+	require.NoError(t, store.ReleaseSlot(ctx, "slots:{ip:1}", "lease-1"))
+
+	acquired, err = store.AcquireSlot(ctx, "slots:{ip:1}", "lease-3", 2, 200*time.Millisecond)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+
+	// A lease that was never released is reclaimed once it ends, while the
+	// longer lease keeps the key alive.
+	acquired, err = store.AcquireSlot(ctx, "slots:{ip:2}", "long", 2, time.Second)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+	acquired, err = store.AcquireSlot(ctx, "slots:{ip:2}", "short", 2, 100*time.Millisecond)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+
+	store.advance(150 * time.Millisecond)
+
+	acquired, err = store.AcquireSlot(ctx, "slots:{ip:2}", "next", 2, 100*time.Millisecond)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+	acquired, err = store.AcquireSlot(ctx, "slots:{ip:2}", "over", 2, 100*time.Millisecond)
+	require.NoError(t, err)
+	assert.False(t, acquired)
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
+)
+
+// TimeoutStorage gives each call to storage at most timeout, within the
+// deadline of its own context, so a stalled Redis fails requests quickly
+// instead of holding them.
+//
+// Like FailoverStorage, it implements every optional storage interface.
+type TimeoutStorage struct {
+	storage domain.Storage
+	timeout time.Duration
+}
+
+func NewTimeoutStorage(storage domain.Storage, timeout time.Duration) *TimeoutStorage {
+	return &TimeoutStorage{
+		storage: storage,
+		timeout: timeout,
+	}
+}
+
+func (t *TimeoutStorage) do(ctx context.Context, op func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	return op(ctx)
+}
+
+func (t *TimeoutStorage) Increment(ctx context.Context, key string, expiration time.Duration) (int64, error) {
+	return t.IncrementBy(ctx, key, 1, expiration)
+}
+
+func (t *TimeoutStorage) IncrementBy(ctx context.Context, key string, value int64, expiration time.Duration) (int64, error) {
+	var count int64
+	err := t.do(ctx, func(ctx context.Context) (err error) {
+		count, err = t.storage.IncrementBy(ctx, key, value, expiration)
+		return err
+	})
+	return count, err
+}
+
+func (t *TimeoutStorage) Get(ctx context.Context, key string) (int64, error) {
+	var value int64
+	err := t.do(ctx, func(ctx context.Context) (err error) {
+		value, err = t.storage.Get(ctx, key)
+		return err
+	})
+	return value, err
+}
+
+func (t *TimeoutStorage) SetBlock(ctx context.Context, key string, duration time.Duration) error {
+	return t.do(ctx, func(ctx context.Context) error {
+		return t.storage.SetBlock(ctx, key, duration)
+	})
+}
+
+func (t *TimeoutStorage) IsBlocked(ctx context.Context, key string) (bool, error) {
+	var blocked bool
+	err := t.do(ctx, func(ctx context.Context) (err error) {
+		blocked, err = t.storage.IsBlocked(ctx, key)
+		return err
+	})
+	return blocked, err
+}
+
+func (t *TimeoutStorage) GetTTL(ctx context.Context, key string) (time.Duration, error) {
+	var ttl time.Duration
+	err := t.do(ctx, func(ctx context.Context) (err error) {
+		ttl, err = t.storage.GetTTL(ctx, key)
+		return err
+	})
+	return ttl, err
+}
+
+func (t *TimeoutStorage) AcquireSlot(ctx context.Context, key, leaseID string, limit int, lease time.Duration) (bool, error) {
+	var acquired bool
+	err := t.do(ctx, func(ctx context.Context) (err error) {
+		acquired, err = t.storage.AcquireSlot(ctx, key, leaseID, limit, lease)
+		return err
+	})
+	return acquired, err
+}
+
+func (t *TimeoutStorage) ReleaseSlot(ctx context.Context, key, leaseID string) error {
+	return t.do(ctx, func(ctx context.Context) error {
+		return t.storage.ReleaseSlot(ctx, key, leaseID)
+	})
+}
+
+func (t *TimeoutStorage) RecordOffense(ctx context.Context, key string, memory time.Duration) (int64, error) {
+	var count int64
+	err := t.do(ctx, func(ctx context.Context) (err error) {
+		count, err = t.storage.RecordOffense(ctx, key, memory)
+		return err
+	})
+	return count, err
+}
+
+func (t *TimeoutStorage) CheckAndBlock(ctx context.Context, blockKey string, counters []domain.WindowCounter, cost int, blockDuration time.Duration) (*domain.RateLimitStatus, error) {
+	return t.status(ctx, func(ctx context.Context) (*domain.RateLimitStatus, error) {
+		return checkAndBlock(ctx, t.storage, blockKey, counters, cost, blockDuration)
+	})
+}
+
+func (t *TimeoutStorage) TakeToken(ctx context.Context, key string, capacity int, refillRate float64, cost int) (*domain.RateLimitStatus, error) {
+	return t.status(ctx, func(ctx context.Context) (*domain.RateLimitStatus, error) {
+		return takeToken(ctx, t.storage, key, capacity, refillRate, cost)
+	})
+}
+
+func (t *TimeoutStorage) SlidingLog(ctx context.Context, blockKey, key string, maxRequests, cost int, window, blockDuration time.Duration) (*domain.RateLimitStatus, error) {
+	return t.status(ctx, func(ctx context.Context) (*domain.RateLimitStatus, error) {
+		return slidingLog(ctx, t.storage, blockKey, key, maxRequests, cost, window, blockDuration)
+	})
+}
+
+func (t *TimeoutStorage) SlidingWindowCounter(ctx context.Context, blockKey, key string, maxRequests, cost int, window, blockDuration time.Duration) (*domain.RateLimitStatus, error) {
+	return t.status(ctx, func(ctx context.Context) (*domain.RateLimitStatus, error) {
+		return slidingWindowCounter(ctx, t.storage, blockKey, key, maxRequests, cost, window, blockDuration)
+	})
+}
+
+func (t *TimeoutStorage) GCRA(ctx context.Context, key string, emissionInterval, tolerance time.Duration, cost int) (*domain.RateLimitStatus, error) {
+	return t.status(ctx, func(ctx context.Context) (*domain.RateLimitStatus, error) {
+		return gcra(ctx, t.storage, key, emissionInterval, tolerance, cost)
+	})
+}
+
+func (t *TimeoutStorage) status(ctx context.Context, op func(ctx context.Context) (*domain.RateLimitStatus, error)) (*domain.RateLimitStatus, error) {
+	var status *domain.RateLimitStatus
+	err := t.do(ctx, func(ctx context.Context) (err error) {
+		status, err = op(ctx)
+		return err
+	})
+	return status, err
+}
+
+func (t *TimeoutStorage) Close() error {
+	return t.storage.Close()
+}
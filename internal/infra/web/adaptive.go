@@ -0,0 +1,147 @@
+package web
+
+import (
+	"expvar"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
+)
+
+// adaptiveScale is the scale of the last AdaptiveLimit created or adjusted.
+// It is published with expvar.
+var adaptiveScale = expvar.NewFloat("adaptive_limit_scale")
+
+// AdaptiveLimit scales the configured limits with AIMD based on the health
+// of the protected handler. After every interval with observations, the
+// scale grows by increase when the average latency and the 5xx rate are
+// within target, and is multiplied by decrease otherwise. The scale stays
+// between floor and ceiling, both fractions of the configured limits.
+type AdaptiveLimit struct {
+	targetLatency time.Duration
+	maxErrorRate  float64
+	floor         float64
+	ceiling       float64
+	increase      float64
+	decrease      float64
+	interval      time.Duration
+
+	mu          sync.Mutex
+	scale       float64
+	windowStart time.Time
+	requests    int
+	errors      int
+	latency     time.Duration
+}
+
+func NewAdaptiveLimit(targetLatency time.Duration, maxErrorRate, floor, ceiling float64) *AdaptiveLimit {
+	adaptiveScale.Set(ceiling)
+
+	return &AdaptiveLimit{
+		targetLatency: targetLatency,
+		maxErrorRate:  maxErrorRate,
+		floor:         floor,
+		ceiling:       ceiling,
+		increase:      0.05,
+		decrease:      0.5,
+		interval:      time.Second,
+		scale:         ceiling,
+		windowStart:   time.Now(),
+	}
+}
+
+// SetSteps changes the additive increase, the multiplicative decrease and
+// how often they are applied. The defaults are 0.05, 0.5 and one second.
+func (a *AdaptiveLimit) SetSteps(increase, decrease float64, interval time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.increase = increase
+	a.decrease = decrease
+	a.interval = interval
+}
+
+// Scale returns the fraction of the configured limits currently enforced.
+func (a *AdaptiveLimit) Scale() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.scale
+}
+
+// Observe records a response served by the protected handler.
+func (a *AdaptiveLimit) Observe(latency time.Duration, statusCode int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.requests++
+	a.latency += latency
+	if statusCode >= http.StatusInternalServerError {
+		a.errors++
+	}
+
+	now := time.Now()
+	if now.Sub(a.windowStart) < a.interval {
+		return
+	}
+
+	average := a.latency / time.Duration(a.requests)
+	errorRate := float64(a.errors) / float64(a.requests)
+
+	if average > a.targetLatency || errorRate > a.maxErrorRate {
+		a.scale = math.Max(a.floor, a.scale*a.decrease)
+		log.Printf("Adaptive limit decreased to %.2f (latency %s, error rate %.2f)", a.scale, average, errorRate)
+	} else {
+		a.scale = math.Min(a.ceiling, a.scale+a.increase)
+	}
+	adaptiveScale.Set(a.scale)
+
+	a.windowStart = now
+	a.requests = 0
+	a.errors = 0
+	a.latency = 0
+}
+
+// Apply returns config with its request limits scaled. Quotas are kept, as
+// they are not meant to protect the backend.
+func (a *AdaptiveLimit) Apply(config domain.RateLimitConfig) domain.RateLimitConfig {
+	scale := a.Scale()
+
+	config.MaxRequests = scaleLimit(config.MaxRequests, scale)
+	config.Burst = scaleLimit(config.Burst, scale)
+	if len(config.Limits) > 0 {
+		limits := make([]domain.Limit, len(config.Limits))
+		for i, limit := range config.Limits {
+			limits[i] = domain.Limit{MaxRequests: scaleLimit(limit.MaxRequests, scale), Window: limit.Window}
+		}
+		config.Limits = limits
+	}
+
+	return config
+}
+
+// scaleLimit rounds up so a positive limit never drops to zero.
+func scaleLimit(limit int, scale float64) int {
+	if limit <= 0 {
+		return limit
+	}
+	return int(math.Ceil(float64(limit) * scale))
+}
+
+// statusRecorder captures the status code written by the handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (r *statusRecorder) WriteHeader(statusCode int) {
+	r.statusCode = statusCode
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
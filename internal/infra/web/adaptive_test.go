@@ -0,0 +1,114 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
+	"github.com/eduardohermesneto/rate-limiter/internal/infra/storage"
+	"github.com/eduardohermesneto/rate-limiter/internal/usecase"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdaptiveLimit_AIMD(t *testing.T) {
+	adaptive := NewAdaptiveLimit(100*time.Millisecond, 0.1, 0.2, 1)
+	adaptive.SetSteps(0.1, 0.5, 0)
+
+	adaptive.Observe(10*time.Millisecond, http.StatusOK)
+	assert.Equal(t, 1.0, adaptive.Scale())
+
+	adaptive.Observe(500*time.Millisecond, http.StatusOK)
+	assert.Equal(t, 0.5, adaptive.Scale())
+
+	adaptive.Observe(10*time.Millisecond, http.StatusServiceUnavailable)
+	assert.Equal(t, 0.25, adaptive.Scale())
+
+	adaptive.Observe(10*time.Millisecond, http.StatusBadGateway)
+	assert.Equal(t, 0.2, adaptive.Scale())
+
+	adaptive.Observe(10*time.Millisecond, http.StatusOK)
+	assert.InDelta(t, 0.3, adaptive.Scale(), 1e-9)
+	assert.InDelta(t, 0.3, adaptiveScale.Value(), 1e-9)
+}
+
+func TestAdaptiveLimit_Apply(t *testing.T) {
+	adaptive := NewAdaptiveLimit(time.Second, 0.1, 0.1, 0.5)
+
+	config := adaptive.Apply(domain.RateLimitConfig{
+		MaxRequests: 10,
+		Burst:       3,
+		Limits:      []domain.Limit{{MaxRequests: 1, Window: time.Second}, {MaxRequests: 100, Window: time.Minute}},
+		Quotas:      []domain.Quota{{MaxRequests: 1000, Period: domain.PeriodDay}},
+	})
+
+	assert.Equal(t, 5, config.MaxRequests)
+	assert.Equal(t, 2, config.Burst)
+	assert.Equal(t, []domain.Limit{{MaxRequests: 1, Window: time.Second}, {MaxRequests: 50, Window: time.Minute}}, config.Limits)
+	assert.Equal(t, 1000, config.Quotas[0].MaxRequests)
+}
+
+func TestMiddleware_AdaptiveLimit(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	limiter := usecase.NewRateLimiter(store, 10, 10, time.Second)
+	adaptive := NewAdaptiveLimit(time.Second, 0.1, 0.1, 1)
+	adaptive.SetSteps(0.05, 0.5, 0)
+
+	middleware := NewRateLimiterMiddleware(limiter)
+	middleware.SetAdaptive(adaptive)
+
+	handler := middleware.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	serve := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.RemoteAddr = "192.168.1.1:12345"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve()
+	assert.Equal(t, "10", rec.Header().Get(HeaderRateLimitLimit))
+	assert.Equal(t, 0.5, adaptive.Scale())
+
+	rec = serve()
+	assert.Equal(t, "5", rec.Header().Get(HeaderRateLimitLimit))
+	assert.Equal(t, 0.25, adaptive.Scale())
+
+	rec = serve()
+	assert.Equal(t, "3", rec.Header().Get(HeaderRateLimitLimit))
+
+	rec = serve()
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+}
+
+func TestMiddleware_AdaptiveLimitHeaderWithWindows(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	limiter := usecase.NewRateLimiter(store, 10, 10, time.Second)
+	limiter.SetWindowLimits([]domain.Limit{{MaxRequests: 100, Window: time.Second}, {MaxRequests: 4, Window: time.Minute}}, nil)
+	middleware := NewRateLimiterMiddleware(limiter)
+	middleware.SetAdaptive(NewAdaptiveLimit(time.Second, 0.1, 0.1, 0.5))
+
+	handler := middleware.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// The minute window has the fewest requests left, so its scaled limit
+	// is the one reported, even though MaxRequests is unused.
+	for _, code := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.RemoteAddr = "192.168.1.1:12345"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, code, rec.Code)
+		assert.Equal(t, "2", rec.Header().Get(HeaderRateLimitLimit))
+	}
+}
@@ -0,0 +1,40 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+)
+
+// CostFunc returns the number of units a request consumes.
+type CostFunc func(r *http.Request) int
+
+// CostByRoute assigns costs by "METHOD /path" or by "/path" for any method,
+// falling back to defaultCost.
+func CostByRoute(costs map[string]int, defaultCost int) CostFunc {
+	return func(r *http.Request) int {
+		if cost, exists := costs[r.Method+" "+r.URL.Path]; exists {
+			return cost
+		}
+		if cost, exists := costs[r.URL.Path]; exists {
+			return cost
+		}
+		return defaultCost
+	}
+}
+
+type extraCostKey struct{}
+
+// AddCost charges units on top of the request cost once the handler
+// returns. Handlers use it when the cost is only known while serving, e.g.
+// from the response size or the query complexity.
+func AddCost(r *http.Request, units int) {
+	if extra, ok := r.Context().Value(extraCostKey{}).(*atomic.Int64); ok {
+		extra.Add(int64(units))
+	}
+}
+
+func withExtraCost(r *http.Request) (*http.Request, *atomic.Int64) {
+	extra := new(atomic.Int64)
+	return r.WithContext(context.WithValue(r.Context(), extraCostKey{}, extra)), extra
+}
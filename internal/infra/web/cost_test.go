@@ -0,0 +1,73 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eduardohermesneto/rate-limiter/internal/infra/storage"
+	"github.com/eduardohermesneto/rate-limiter/internal/usecase"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCostByRoute(t *testing.T) {
+	cost := CostByRoute(map[string]int{
+		"POST /export": 50,
+		"/ping":        1,
+		"/search":      5,
+	}, 2)
+
+	assert.Equal(t, 50, cost(httptest.NewRequest("POST", "/export", nil)))
+	assert.Equal(t, 2, cost(httptest.NewRequest("GET", "/export", nil)))
+	assert.Equal(t, 5, cost(httptest.NewRequest("GET", "/search", nil)))
+	assert.Equal(t, 1, cost(httptest.NewRequest("DELETE", "/ping", nil)))
+}
+
+func TestMiddleware_WeightedCost(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	limiter := usecase.NewRateLimiter(store, 10, 10, 5*time.Second)
+	middleware := NewRateLimiterMiddleware(limiter)
+	middleware.SetCostFunc(CostByRoute(map[string]int{"/export": 6}, 1))
+
+	handler := middleware.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(path string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = "192.168.1.1:12345"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve("/export"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("/export"))
+}
+
+func TestMiddleware_AddCost(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	limiter := usecase.NewRateLimiter(store, 10, 10, time.Second)
+	middleware := NewRateLimiterMiddleware(limiter)
+
+	handler := middleware.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		AddCost(r, 9)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	codes := make([]int, 0, 2)
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/report", nil)
+		req.RemoteAddr = "192.168.1.1:12345"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+	}
+
+	assert.Equal(t, []int{http.StatusOK, http.StatusTooManyRequests}, codes)
+}
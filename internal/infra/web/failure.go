@@ -0,0 +1,62 @@
+package web
+
+import (
+	"expvar"
+	"net/http"
+	"time"
+
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
+	"github.com/eduardohermesneto/rate-limiter/internal/usecase"
+)
+
+// failures counts the requests whose limits could not be checked, by the
+// failure mode applied. It is published with expvar.
+var failures = expvar.NewMap("rate_limit_failures")
+
+// strictness orders the failure modes, so the strictest one of the rules
+// matching a request applies.
+var strictness = map[domain.FailureMode]int{
+	domain.FailureModeOpen:   0,
+	domain.FailureModeLocal:  1,
+	domain.FailureModeError:  2,
+	domain.FailureModeClosed: 3,
+}
+
+// SetFailureMode sets what happens to requests whose limits cannot be
+// checked, unless their rules set their own mode. The default is
+// domain.FailureModeError. retryAfter is sent by domain.FailureModeClosed
+// and local is the in-process limiter used by domain.FailureModeLocal.
+func (m *RateLimiterMiddleware) SetFailureMode(mode domain.FailureMode, retryAfter time.Duration, local *usecase.RateLimiter) {
+	m.onFailure = mode
+	m.failureRetry = retryAfter
+	m.local = local
+}
+
+// failureMode returns the strictest failure mode of rules, using the
+// default one for requests matching no rule and rules without a mode.
+func (m *RateLimiterMiddleware) failureMode(rules []domain.Rule) domain.FailureMode {
+	fallback := m.onFailure
+	if fallback == "" {
+		fallback = domain.FailureModeError
+	}
+	if len(rules) == 0 {
+		return fallback
+	}
+
+	var mode domain.FailureMode
+	for _, rule := range rules {
+		candidate := rule.OnFailure
+		if candidate == "" {
+			candidate = fallback
+		}
+		if mode == "" || strictness[candidate] > strictness[mode] {
+			mode = candidate
+		}
+	}
+	return mode
+}
+
+func (m *RateLimiterMiddleware) writeFailureClosed(w http.ResponseWriter) {
+	w.Header().Set(HeaderRetryAfter, retryAfterSeconds(time.Now().Add(m.failureRetry)))
+	http.Error(w, Message503, http.StatusServiceUnavailable)
+}
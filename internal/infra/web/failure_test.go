@@ -0,0 +1,81 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
+	"github.com/eduardohermesneto/rate-limiter/internal/infra/storage"
+	"github.com/eduardohermesneto/rate-limiter/internal/usecase"
+	"github.com/stretchr/testify/assert"
+)
+
+// downStorage fails every rate limit check.
+type downStorage struct {
+	*storage.MemoryStorage
+}
+
+func (s downStorage) CheckAndBlock(ctx context.Context, blockKey string, counters []domain.WindowCounter, cost int, blockDuration time.Duration) (*domain.RateLimitStatus, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestMiddleware_FailureModes(t *testing.T) {
+	store := downStorage{storage.NewMemoryStorage()}
+	defer store.Close()
+
+	local := usecase.NewRateLimiter(storage.NewMemoryStorage(), 2, 2, time.Minute)
+
+	middleware := NewRateLimiterMiddleware(usecase.NewRateLimiter(store, 2, 2, time.Minute))
+	middleware.SetRules(domain.RuleSet{Rules: []domain.Rule{
+		{Name: "open", PathPrefix: "/open", Strategy: domain.StrategyFixedWindow, MaxRequests: 1, Window: time.Minute, OnFailure: domain.FailureModeOpen},
+		{Name: "closed", PathPrefix: "/closed", Strategy: domain.StrategyFixedWindow, MaxRequests: 1, Window: time.Minute, OnFailure: domain.FailureModeClosed},
+		{Name: "local", PathPrefix: "/local", Strategy: domain.StrategyFixedWindow, MaxRequests: 1, Window: time.Minute, OnFailure: domain.FailureModeLocal},
+	}})
+
+	handler := middleware.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = "192.168.1.1:12345"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusInternalServerError, serve("/test").Code)
+
+	middleware.SetFailureMode(domain.FailureModeOpen, 10*time.Second, local)
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, serve("/test").Code)
+		assert.Equal(t, http.StatusOK, serve("/open").Code)
+	}
+
+	rec := serve("/closed")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "10", rec.Header().Get(HeaderRetryAfter))
+
+	assert.Equal(t, http.StatusOK, serve("/local").Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve("/local").Code)
+}
+
+func TestMiddleware_StrictestFailureMode(t *testing.T) {
+	middleware := NewRateLimiterMiddleware(nil)
+
+	assert.Equal(t, domain.FailureModeError, middleware.failureMode(nil))
+
+	middleware.SetFailureMode(domain.FailureModeLocal, time.Second, nil)
+	assert.Equal(t, domain.FailureModeLocal, middleware.failureMode(nil))
+
+	rules := []domain.Rule{{OnFailure: domain.FailureModeOpen}, {}}
+	assert.Equal(t, domain.FailureModeLocal, middleware.failureMode(rules))
+
+	rules = append(rules, domain.Rule{OnFailure: domain.FailureModeClosed})
+	assert.Equal(t, domain.FailureModeClosed, middleware.failureMode(rules))
+}
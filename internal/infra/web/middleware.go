@@ -2,27 +2,94 @@ package web
 
 import (
 	"context"
+	"log"
+	"math"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
 	"github.com/eduardohermesneto/rate-limiter/internal/usecase"
 )
 
 const (
 	HeaderAPIKey          = "API_KEY"
 	Message429            = "you have reached the maximum number of requests or actions allowed within a certain time frame"
+	Message503            = "the service is overloaded, please retry later"
 	HeaderRateLimitRemain = "X-RateLimit-Remaining"
+	HeaderRetryAfter      = "Retry-After"
+	HeaderRateLimitLimit  = "X-RateLimit-Limit"
 )
 
 type RateLimiterMiddleware struct {
+	policy   atomic.Pointer[policy]
+	queue    *LeakyQueue
+	cost     CostFunc
+	adaptive *AdaptiveLimit
+	shedder  *LoadShedder
+	classify []PriorityFunc
+
+	onFailure    domain.FailureMode
+	failureRetry time.Duration
+	local        *usecase.RateLimiter
+}
+
+// policy is the limiter and rules a request is checked against. It is
+// replaced as a whole on reload, so a request never mixes old and new
+// settings.
+type policy struct {
 	limiter *usecase.RateLimiter
+	rules   domain.RuleSet
 }
 
 func NewRateLimiterMiddleware(limiter *usecase.RateLimiter) *RateLimiterMiddleware {
-	return &RateLimiterMiddleware{
-		limiter: limiter,
-	}
+	m := &RateLimiterMiddleware{}
+	m.policy.Store(&policy{limiter: limiter})
+	return m
+}
+
+// Reload swaps the limiter and rules used by new requests. Requests being
+// checked keep the previous ones.
+func (m *RateLimiterMiddleware) Reload(limiter *usecase.RateLimiter, rules domain.RuleSet) {
+	m.policy.Store(&policy{limiter: limiter, rules: rules})
+}
+
+// SetQueue enables the leaky bucket mode: requests over the limit are
+// delayed by the queue instead of being rejected right away, then checked
+// again. Exceeding a limit no longer blocks the key, as a block would
+// usually outlast the queue's maximum wait; keys already blocked, e.g. by
+// another instance, are still rejected.
+func (m *RateLimiterMiddleware) SetQueue(queue *LeakyQueue) {
+	m.queue = queue
+}
+
+// SetAdaptive scales the limits with adaptive, which observes the latency
+// and status of every request served by next.
+func (m *RateLimiterMiddleware) SetAdaptive(adaptive *AdaptiveLimit) {
+	m.adaptive = adaptive
+}
+
+// SetLoadShedder rejects requests with 503 when shedder is at capacity for
+// their priority. The priority is the first one returned by classify,
+// PriorityNormal when none matches.
+func (m *RateLimiterMiddleware) SetLoadShedder(shedder *LoadShedder, classify ...PriorityFunc) {
+	m.shedder = shedder
+	m.classify = classify
+}
+
+// SetRules applies the limits of the rules matching each request instead
+// of the default IP and token limits. Requests matching no rule keep the
+// defaults.
+func (m *RateLimiterMiddleware) SetRules(rules domain.RuleSet) {
+	m.Reload(m.policy.Load().limiter, rules)
+}
+
+// SetCostFunc makes each request consume cost(r) units instead of one.
+func (m *RateLimiterMiddleware) SetCostFunc(cost CostFunc) {
+	m.cost = cost
 }
 
 func (m *RateLimiterMiddleware) Handle(next http.Handler) http.Handler {
@@ -33,49 +100,208 @@ func (m *RateLimiterMiddleware) Handle(next http.Handler) http.Handler {
 			return
 		}
 
-		ctx := context.Background()
+		if m.shedder != nil {
+			if !m.shedder.Acquire(m.priority(r)) {
+				m.shedder.writeServiceUnavailable(w)
+				return
+			}
+			defer m.shedder.Release()
+		}
 
-		token := r.Header.Get(HeaderAPIKey)
+		ctx := r.Context()
+		current := m.policy.Load()
 
+		var config domain.RateLimitConfig
+
+		token := r.Header.Get(HeaderAPIKey)
 		if token != "" {
-			status, err := m.limiter.CheckToken(ctx, token)
-			if err != nil {
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			config = current.limiter.TokenConfig(token)
+		} else {
+			ip := extractIP(r)
+			if ip == "" {
+				http.Error(w, "Cannot determine IP address", http.StatusBadRequest)
 				return
 			}
 
-			if !status.Allowed {
-				w.Header().Set(HeaderRateLimitRemain, "0")
-				http.Error(w, Message429, http.StatusTooManyRequests)
-				return
+			config = current.limiter.IPConfig(ip)
+		}
+
+		cost := 1
+		if m.cost != nil {
+			cost = m.cost(r)
+		}
+
+		var levels []domain.RateLimitConfig
+		rules := matchRules(current.rules, r, config.Type)
+		if len(rules) > 0 {
+			for _, rule := range rules {
+				levels = append(levels, current.limiter.RuleConfig(rule, config.Type, config.Key))
+			}
+			levels = append(levels, current.limiter.Enclosing(config.Type, config.Key)...)
+		} else {
+			levels = current.limiter.Chain(config)
+		}
+		for i := range levels {
+			if m.adaptive != nil {
+				levels[i] = m.adaptive.Apply(levels[i])
+			}
+			if m.queue != nil {
+				levels[i].BlockDuration = 0
+				levels[i].Escalation = domain.Escalation{}
 			}
+			levels[i].Cost = cost
+		}
 
-			next.ServeHTTP(w, r)
+		// limiter is the one the request was counted by, nil when it was let
+		// through unchecked.
+		limiter, status, ok := m.check(ctx, w, current.limiter, levels, rules)
+		if !ok {
 			return
 		}
 
-		ip := extractIP(r)
-		if ip == "" {
-			http.Error(w, "Cannot determine IP address", http.StatusBadRequest)
+		if !status.Allowed && m.queue != nil {
+			// Queued requests are checked again once released, so the queue
+			// smooths bursts down to the limit instead of adding to it.
+			queueCtx, cancel := context.WithTimeout(ctx, m.queue.maxWait)
+			defer cancel()
+
+			key := string(config.Type) + ":" + config.Key
+			for !status.Allowed && m.queue.Wait(queueCtx, key, status.BlockedUntil) {
+				if limiter, status, ok = m.check(ctx, w, current.limiter, levels, rules); !ok {
+					return
+				}
+			}
+		}
+		if m.adaptive != nil && status.Limit > 0 {
+			w.Header().Set(HeaderRateLimitLimit, strconv.Itoa(status.Limit))
+		}
+		if !status.Allowed {
+			writeTooManyRequests(w, status)
 			return
 		}
 
-		status, err := m.limiter.CheckIP(ctx, ip)
+		r, extra := withExtraCost(r)
+		if m.adaptive != nil {
+			recorder := &statusRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+			start := time.Now()
+			next.ServeHTTP(recorder, r)
+			m.adaptive.Observe(time.Since(start), recorder.statusCode)
+		} else {
+			next.ServeHTTP(w, r)
+		}
+
+		if units := extra.Load(); units > 0 && limiter != nil {
+			for _, level := range levels {
+				if err := limiter.Charge(context.WithoutCancel(ctx), level, int(units)); err != nil {
+					log.Printf("Failed to charge additional cost: %v", err)
+				}
+			}
+		}
+	})
+}
+
+// check counts the request against levels, applying the failure mode of
+// rules when limiter fails. It returns the limiter the request was counted
+// by, and false once it has written an error response.
+func (m *RateLimiterMiddleware) check(ctx context.Context, w http.ResponseWriter, limiter *usecase.RateLimiter, levels []domain.RateLimitConfig, rules []domain.Rule) (*usecase.RateLimiter, *domain.RateLimitStatus, bool) {
+	status, err := limiter.CheckChain(ctx, levels)
+	if err != nil {
+		mode := m.failureMode(rules)
+		failures.Add(string(mode), 1)
+
+		switch mode {
+		case domain.FailureModeOpen:
+			return nil, &domain.RateLimitStatus{Allowed: true}, true
+		case domain.FailureModeClosed:
+			m.writeFailureClosed(w)
+			return nil, nil, false
+		case domain.FailureModeLocal:
+			if m.local != nil {
+				limiter = m.local
+				status, err = limiter.CheckChain(ctx, levels)
+			}
+		}
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+
+	return limiter, status, true
+}
+
+// LimitConcurrency caps the simultaneous requests per token or IP served by
+// next, releasing the slot once next returns. Use it around expensive
+// endpoints.
+func (m *RateLimiterMiddleware) LimitConcurrency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		limiter := m.policy.Load().limiter
+
+		var (
+			status  *domain.RateLimitStatus
+			release usecase.ReleaseFunc
+			err     error
+		)
+
+		token := r.Header.Get(HeaderAPIKey)
+		if token != "" {
+			status, release, err = limiter.AcquireToken(ctx, token)
+		} else {
+			ip := extractIP(r)
+			if ip == "" {
+				http.Error(w, "Cannot determine IP address", http.StatusBadRequest)
+				return
+			}
+
+			status, release, err = limiter.AcquireIP(ctx, ip)
+		}
+
 		if err != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
 		if !status.Allowed {
-			w.Header().Set(HeaderRateLimitRemain, "0")
-			http.Error(w, Message429, http.StatusTooManyRequests)
+			writeTooManyRequests(w, status)
 			return
 		}
 
+		defer func() {
+			if err := release(); err != nil {
+				log.Printf("Failed to release concurrency slot: %v", err)
+			}
+		}()
+
 		next.ServeHTTP(w, r)
 	})
 }
 
+func (m *RateLimiterMiddleware) priority(r *http.Request) domain.Priority {
+	for _, classify := range m.classify {
+		if priority, ok := classify(r); ok {
+			return priority
+		}
+	}
+	return domain.PriorityNormal
+}
+
+func writeTooManyRequests(w http.ResponseWriter, status *domain.RateLimitStatus) {
+	w.Header().Set(HeaderRateLimitRemain, "0")
+	if !status.BlockedUntil.IsZero() {
+		w.Header().Set(HeaderRetryAfter, retryAfterSeconds(status.BlockedUntil))
+	}
+	http.Error(w, Message429, http.StatusTooManyRequests)
+}
+
+func retryAfterSeconds(until time.Time) string {
+	seconds := int(math.Ceil(time.Until(until).Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
+
 func extractIP(r *http.Request) string {
 	forwarded := r.Header.Get("X-Forwarded-For")
 	if forwarded != "" {
@@ -1,11 +1,15 @@
 package web
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
 	"github.com/eduardohermesneto/rate-limiter/internal/infra/storage"
 	"github.com/eduardohermesneto/rate-limiter/internal/usecase"
 	"github.com/stretchr/testify/assert"
@@ -151,3 +155,227 @@ func TestExtractIP(t *testing.T) {
 		})
 	}
 }
+
+func TestMiddleware_RetryAfter(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	limiter := usecase.NewRateLimiter(store, 1, 10, 5*time.Second)
+	middleware := NewRateLimiterMiddleware(limiter)
+
+	handler := middleware.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.RemoteAddr = "192.168.1.1:12345"
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		if i == 1 {
+			assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+			assert.Equal(t, "5", rec.Header().Get(HeaderRetryAfter))
+		}
+	}
+}
+
+func TestMiddleware_QueueDelaysOverLimit(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	// The block would outlast the queue, so queueing skips it.
+	limiter := usecase.NewRateLimiter(store, 1, 10, 5*time.Minute)
+	limiter.SetBlockEscalation([]time.Duration{time.Minute, time.Hour}, time.Hour)
+	middleware := NewRateLimiterMiddleware(limiter)
+
+	queue := NewLeakyQueue(10, 2, 3*time.Second)
+	defer queue.Close()
+	middleware.SetQueue(queue)
+
+	handler := middleware.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	start := time.Now()
+	codes := make([]int, 0, 3)
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.RemoteAddr = "192.168.1.1:12345"
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+	}
+
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusOK}, codes)
+	// One request per second: the queued ones wait for the next windows.
+	assert.GreaterOrEqual(t, time.Since(start), 2*time.Second)
+}
+
+func TestMiddleware_QueueKeepsThroughputAtLimit(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	limiter := usecase.NewRateLimiter(store, 2, 10, 0)
+	middleware := NewRateLimiterMiddleware(limiter)
+
+	queue := NewLeakyQueue(10, 20, 1500*time.Millisecond)
+	defer queue.Close()
+	middleware.SetQueue(queue)
+
+	var served atomic.Int64
+	handler := middleware.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("GET", "/test", nil)
+			req.RemoteAddr = "192.168.1.1:12345"
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+	}
+	wg.Wait()
+
+	// Two windows fit in the maximum wait, each serving the limit.
+	assert.Equal(t, int64(4), served.Load())
+}
+
+func TestMiddleware_QueueRespectsBlock(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	limiter := usecase.NewRateLimiter(store, 2, 10, 0)
+	middleware := NewRateLimiterMiddleware(limiter)
+
+	queue := NewLeakyQueue(10, 20, time.Second)
+	defer queue.Close()
+	middleware.SetQueue(queue)
+
+	handler := middleware.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// A block set elsewhere, e.g. by an instance without the queue, is
+	// longer than the maximum wait.
+	require.NoError(t, store.SetBlock(context.Background(), "block:{ip:192.168.1.1}", 5*time.Second))
+
+	start := time.Now()
+	codes := make([]int, 0, 2)
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.RemoteAddr = "192.168.1.1:12345"
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+	}
+
+	assert.Equal(t, []int{http.StatusTooManyRequests, http.StatusTooManyRequests}, codes)
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestMiddleware_LimitConcurrency(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	limiter := usecase.NewRateLimiter(store, 10, 10, 5*time.Second)
+	limiter.SetConcurrencyLimits(1, 1, time.Minute)
+	middleware := NewRateLimiterMiddleware(limiter)
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	handler := middleware.LimitConcurrency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-finish
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	done := make(chan int)
+	go func() {
+		req := httptest.NewRequest("GET", "/slow", nil)
+		req.RemoteAddr = "192.168.1.1:12345"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		done <- rec.Code
+	}()
+	<-started
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.RemoteAddr = "192.168.1.1:12345"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+	close(finish)
+	assert.Equal(t, http.StatusOK, <-done)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/test", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestMiddleware_Reload(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	middleware := NewRateLimiterMiddleware(usecase.NewRateLimiter(store, 1, 10, 0))
+
+	handler := middleware.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func() int {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.RemoteAddr = "192.168.1.1:12345"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve())
+	assert.Equal(t, http.StatusTooManyRequests, serve())
+
+	middleware.Reload(usecase.NewRateLimiter(store, 3, 10, 0), domain.RuleSet{})
+
+	assert.Equal(t, http.StatusOK, serve())
+	assert.Equal(t, http.StatusOK, serve())
+	assert.Equal(t, http.StatusTooManyRequests, serve())
+}
+
+func TestMiddleware_ReloadConcurrent(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	middleware := NewRateLimiterMiddleware(usecase.NewRateLimiter(store, 1000, 1000, 0))
+
+	handler := middleware.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				req := httptest.NewRequest("GET", "/test", nil)
+				req.RemoteAddr = "192.168.1.1:12345"
+				handler.ServeHTTP(httptest.NewRecorder(), req)
+			}
+		}()
+	}
+
+	for i := 0; i < 50; i++ {
+		middleware.Reload(usecase.NewRateLimiter(store, 1000+i, 1000, 0), domain.RuleSet{})
+	}
+	wg.Wait()
+}
@@ -0,0 +1,111 @@
+package web
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// LeakyQueue delays over-limit requests instead of rejecting them. Each key
+// has a bounded queue drained at a fixed rate; requests that would wait
+// longer than maxWait, or find the queue full, are rejected.
+type LeakyQueue struct {
+	interval time.Duration
+	capacity int
+	maxWait  time.Duration
+	keys     map[string]*queueState
+	mu       sync.Mutex
+	done     chan struct{}
+}
+
+type queueState struct {
+	next    time.Time
+	waiting int
+}
+
+func NewLeakyQueue(rate float64, capacity int, maxWait time.Duration) *LeakyQueue {
+	queue := &LeakyQueue{
+		interval: time.Duration(float64(time.Second) / rate),
+		capacity: capacity,
+		maxWait:  maxWait,
+		keys:     make(map[string]*queueState),
+		done:     make(chan struct{}),
+	}
+
+	go queue.cleanupIdle()
+
+	return queue
+}
+
+func (q *LeakyQueue) cleanupIdle() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.done:
+			return
+		case <-ticker.C:
+			q.mu.Lock()
+			now := time.Now()
+			for key, state := range q.keys {
+				if state.waiting == 0 && now.After(state.next) {
+					delete(q.keys, key)
+				}
+			}
+			q.mu.Unlock()
+		}
+	}
+}
+
+// Wait blocks until the request is released from the queue of key, never
+// before notBefore. It returns false when the request could not be queued,
+// would be released after the deadline of ctx, or ctx was canceled.
+func (q *LeakyQueue) Wait(ctx context.Context, key string, notBefore time.Time) bool {
+	q.mu.Lock()
+
+	state, exists := q.keys[key]
+	if !exists {
+		state = &queueState{}
+		q.keys[key] = state
+	}
+
+	now := time.Now()
+	release := state.next
+	if release.Before(now) {
+		release = now
+	}
+	if release.Before(notBefore) {
+		release = notBefore
+	}
+
+	deadline, hasDeadline := ctx.Deadline()
+	if state.waiting >= q.capacity || release.Sub(now) > q.maxWait || (hasDeadline && release.After(deadline)) {
+		q.mu.Unlock()
+		return false
+	}
+
+	state.next = release.Add(q.interval)
+	state.waiting++
+	q.mu.Unlock()
+
+	defer func() {
+		q.mu.Lock()
+		state.waiting--
+		q.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(release.Sub(now))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (q *LeakyQueue) Close() {
+	close(q.done)
+}
@@ -0,0 +1,53 @@
+package web
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeakyQueue_ReleasesAtRate(t *testing.T) {
+	queue := NewLeakyQueue(20, 5, time.Second)
+	defer queue.Close()
+
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.True(t, queue.Wait(context.Background(), "ip:10.0.0.1", time.Time{}))
+		}()
+	}
+	wg.Wait()
+
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+}
+
+func TestLeakyQueue_RejectsWhenFull(t *testing.T) {
+	queue := NewLeakyQueue(1, 1, 5*time.Second)
+	defer queue.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	assert.True(t, queue.Wait(ctx, "ip:10.0.0.1", time.Time{}))
+
+	go queue.Wait(ctx, "ip:10.0.0.1", time.Time{})
+	time.Sleep(50 * time.Millisecond)
+
+	assert.False(t, queue.Wait(ctx, "ip:10.0.0.1", time.Time{}))
+	assert.True(t, queue.Wait(ctx, "ip:10.0.0.2", time.Time{}))
+}
+
+func TestLeakyQueue_RejectsAfterMaxWait(t *testing.T) {
+	queue := NewLeakyQueue(1, 10, 500*time.Millisecond)
+	defer queue.Close()
+
+	assert.True(t, queue.Wait(context.Background(), "ip:10.0.0.1", time.Time{}))
+	assert.False(t, queue.Wait(context.Background(), "ip:10.0.0.1", time.Time{}))
+}
@@ -0,0 +1,58 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
+)
+
+// matchRules returns the rules of set applying to r, made by a client of
+// the given identity type.
+func matchRules(set domain.RuleSet, r *http.Request, identity domain.RateLimitType) []domain.Rule {
+	var matched []domain.Rule
+	for _, rule := range set.Rules {
+		if !ruleMatches(rule, r, identity) {
+			continue
+		}
+
+		matched = append(matched, rule)
+		if set.Mode != domain.RuleModeAll {
+			break
+		}
+	}
+	return matched
+}
+
+func ruleMatches(rule domain.Rule, r *http.Request, identity domain.RateLimitType) bool {
+	if rule.Identity != "" && rule.Identity != identity {
+		return false
+	}
+	if rule.PathPrefix != "" && !strings.HasPrefix(r.URL.Path, rule.PathPrefix) {
+		return false
+	}
+	if rule.PathRegex != nil && !rule.PathRegex.MatchString(r.URL.Path) {
+		return false
+	}
+
+	if len(rule.Methods) > 0 {
+		allowed := false
+		for _, method := range rule.Methods {
+			if strings.EqualFold(method, r.Method) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+
+	for _, header := range rule.Headers {
+		if r.Header.Get(header) == "" {
+			return false
+		}
+	}
+
+	return true
+}
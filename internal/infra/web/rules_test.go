@@ -0,0 +1,79 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
+	"github.com/eduardohermesneto/rate-limiter/internal/infra/storage"
+	"github.com/eduardohermesneto/rate-limiter/internal/usecase"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchRules(t *testing.T) {
+	rules := []domain.Rule{
+		{Name: "export", PathPrefix: "/export", Methods: []string{"POST"}, Identity: domain.RateLimitTypeToken},
+		{Name: "search", PathRegex: regexp.MustCompile(`^/api/v[0-9]+/search$`)},
+		{Name: "debug", Headers: []string{"X-Debug"}},
+		{Name: "catch-all"},
+	}
+
+	names := func(mode domain.RuleMode, r *http.Request, identity domain.RateLimitType) []string {
+		var matched []string
+		for _, rule := range matchRules(domain.RuleSet{Mode: mode, Rules: rules}, r, identity) {
+			matched = append(matched, rule.Name)
+		}
+		return matched
+	}
+
+	req := httptest.NewRequest("POST", "/export/csv", nil)
+	assert.Equal(t, []string{"export"}, names(domain.RuleModeFirst, req, domain.RateLimitTypeToken))
+	assert.Equal(t, []string{"catch-all"}, names(domain.RuleModeFirst, req, domain.RateLimitTypeIP))
+
+	req = httptest.NewRequest("GET", "/export/csv", nil)
+	assert.Equal(t, []string{"catch-all"}, names(domain.RuleModeFirst, req, domain.RateLimitTypeToken))
+
+	req = httptest.NewRequest("GET", "/api/v2/search", nil)
+	req.Header.Set("X-Debug", "1")
+	assert.Equal(t, []string{"search", "debug", "catch-all"}, names(domain.RuleModeAll, req, domain.RateLimitTypeIP))
+
+	assert.Empty(t, matchRules(domain.RuleSet{}, req, domain.RateLimitTypeIP))
+}
+
+func TestMiddleware_Rules(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	limiter := usecase.NewRateLimiter(store, 10, 10, time.Second)
+	middleware := NewRateLimiterMiddleware(limiter)
+	middleware.SetRules(domain.RuleSet{
+		Mode: domain.RuleModeAll,
+		Rules: []domain.Rule{
+			{Name: "export", PathPrefix: "/export", Strategy: domain.StrategyFixedWindow, MaxRequests: 2, Window: time.Minute},
+			{Name: "api", PathPrefix: "/", Strategy: domain.StrategyFixedWindow, MaxRequests: 3, Window: time.Minute},
+		},
+	})
+
+	handler := middleware.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(path string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = "192.168.1.1:12345"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve("/export"))
+	assert.Equal(t, http.StatusOK, serve("/export"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("/export"))
+
+	// The denied export request was refunded from the api rule.
+	assert.Equal(t, http.StatusOK, serve("/test"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("/test"))
+}
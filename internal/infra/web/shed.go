@@ -0,0 +1,107 @@
+package web
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
+)
+
+// PriorityFunc classifies a request. It returns false when it has no opinion
+// so the next PriorityFunc is tried.
+type PriorityFunc func(r *http.Request) (domain.Priority, bool)
+
+// PriorityByToken classifies requests by the tier of their API key.
+func PriorityByToken(tiers map[string]domain.Priority) PriorityFunc {
+	return func(r *http.Request) (domain.Priority, bool) {
+		priority, exists := tiers[r.Header.Get(HeaderAPIKey)]
+		return priority, exists
+	}
+}
+
+// PriorityByRoute classifies requests by "METHOD /path" or by "/path" for
+// any method.
+func PriorityByRoute(routes map[string]domain.Priority) PriorityFunc {
+	return func(r *http.Request) (domain.Priority, bool) {
+		if priority, exists := routes[r.Method+" "+r.URL.Path]; exists {
+			return priority, true
+		}
+		priority, exists := routes[r.URL.Path]
+		return priority, exists
+	}
+}
+
+// PriorityByHeader reads the class from header, e.g. "X-Priority: low". The
+// header must be set by a trusted proxy, as clients could otherwise raise
+// their own priority.
+func PriorityByHeader(header string) PriorityFunc {
+	return func(r *http.Request) (domain.Priority, bool) {
+		value := r.Header.Get(header)
+		if value == "" {
+			return 0, false
+		}
+		priority, err := domain.ParsePriority(value)
+		return priority, err == nil
+	}
+}
+
+// LoadShedder caps the requests served at once by the instance. Each
+// priority may only fill the capacity up to its share, so lower classes are
+// rejected first and the rest of the capacity stays reserved for higher
+// ones. Classes without a share may use the whole capacity.
+type LoadShedder struct {
+	capacity   int
+	shares     map[domain.Priority]float64
+	retryAfter time.Duration
+
+	mu       sync.Mutex
+	inFlight int
+}
+
+func NewLoadShedder(capacity int, shares map[domain.Priority]float64, retryAfter time.Duration) *LoadShedder {
+	return &LoadShedder{
+		capacity:   capacity,
+		shares:     shares,
+		retryAfter: retryAfter,
+	}
+}
+
+// Acquire admits a request of priority, returning false when it must be
+// shed. Admitted requests must call Release once served.
+func (s *LoadShedder) Acquire(priority domain.Priority) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	limit := s.capacity
+	if share, exists := s.shares[priority]; exists {
+		limit = int(float64(s.capacity) * share)
+	}
+
+	if s.inFlight >= limit {
+		return false
+	}
+
+	s.inFlight++
+	return true
+}
+
+func (s *LoadShedder) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.inFlight--
+}
+
+// InFlight returns the number of requests being served.
+func (s *LoadShedder) InFlight() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.inFlight
+}
+
+func (s *LoadShedder) writeServiceUnavailable(w http.ResponseWriter) {
+	w.Header().Set(HeaderRetryAfter, retryAfterSeconds(time.Now().Add(s.retryAfter)))
+	http.Error(w, Message503, http.StatusServiceUnavailable)
+}
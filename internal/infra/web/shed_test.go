@@ -0,0 +1,112 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
+	"github.com/eduardohermesneto/rate-limiter/internal/infra/storage"
+	"github.com/eduardohermesneto/rate-limiter/internal/usecase"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadShedder_ReservesCapacity(t *testing.T) {
+	shedder := NewLoadShedder(4, map[domain.Priority]float64{
+		domain.PriorityLow:    0.25,
+		domain.PriorityNormal: 0.5,
+	}, time.Second)
+
+	assert.True(t, shedder.Acquire(domain.PriorityLow))
+	assert.False(t, shedder.Acquire(domain.PriorityLow))
+
+	assert.True(t, shedder.Acquire(domain.PriorityNormal))
+	assert.False(t, shedder.Acquire(domain.PriorityNormal))
+
+	assert.True(t, shedder.Acquire(domain.PriorityCritical))
+	assert.True(t, shedder.Acquire(domain.PriorityCritical))
+	assert.False(t, shedder.Acquire(domain.PriorityCritical))
+	assert.Equal(t, 4, shedder.InFlight())
+
+	for i := 0; i < 3; i++ {
+		shedder.Release()
+	}
+	assert.False(t, shedder.Acquire(domain.PriorityLow))
+	assert.True(t, shedder.Acquire(domain.PriorityNormal))
+}
+
+func TestPriorityFuncs(t *testing.T) {
+	req := httptest.NewRequest("POST", "/export", nil)
+	req.Header.Set(HeaderAPIKey, "gold-token")
+	req.Header.Set("X-Priority", "critical")
+
+	priority, ok := PriorityByToken(map[string]domain.Priority{"gold-token": domain.PriorityCritical})(req)
+	assert.True(t, ok)
+	assert.Equal(t, domain.PriorityCritical, priority)
+
+	priority, ok = PriorityByRoute(map[string]domain.Priority{"POST /export": domain.PriorityLow})(req)
+	assert.True(t, ok)
+	assert.Equal(t, domain.PriorityLow, priority)
+
+	_, ok = PriorityByRoute(map[string]domain.Priority{"GET /export": domain.PriorityLow})(req)
+	assert.False(t, ok)
+
+	priority, ok = PriorityByHeader("X-Priority")(req)
+	assert.True(t, ok)
+	assert.Equal(t, domain.PriorityCritical, priority)
+
+	req.Header.Set("X-Priority", "urgent")
+	_, ok = PriorityByHeader("X-Priority")(req)
+	assert.False(t, ok)
+}
+
+func TestMiddleware_LoadShedding(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	limiter := usecase.NewRateLimiter(store, 100, 100, time.Second)
+	middleware := NewRateLimiterMiddleware(limiter)
+	middleware.SetLoadShedder(
+		NewLoadShedder(2, map[domain.Priority]float64{domain.PriorityLow: 0.5, domain.PriorityNormal: 0.5}, 3*time.Second),
+		PriorityByRoute(map[string]domain.Priority{"/critical": domain.PriorityCritical}),
+	)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := middleware.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = "192.168.1.1:12345"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serve("/slow")
+	}()
+	<-started
+
+	rec := serve("/test")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "3", rec.Header().Get(HeaderRetryAfter))
+
+	rec = serve("/critical")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	close(release)
+	<-done
+
+	rec = serve("/test")
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
@@ -0,0 +1,87 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
+)
+
+const defaultLease = 30 * time.Second
+
+// ReleaseFunc frees a slot reserved by AcquireSlot.
+type ReleaseFunc func() error
+
+// SetConcurrencyLimits caps the in-flight requests per IP and per token.
+// Zero disables the cap for that type.
+func (rl *RateLimiter) SetConcurrencyLimits(ipLimit, tokenLimit int, lease time.Duration) {
+	rl.ipConcurrency = ipLimit
+	rl.tokenConcurrency = tokenLimit
+	rl.lease = lease
+}
+
+// AcquireSlot reserves an in-flight slot for config.Key, allowing at most
+// config.MaxRequests simultaneous holders. The returned ReleaseFunc must
+// be called once the request finishes.
+func (rl *RateLimiter) AcquireSlot(ctx context.Context, config domain.RateLimitConfig) (*domain.RateLimitStatus, ReleaseFunc, error) {
+	leaseID, err := newLeaseID()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate lease id: %w", err)
+	}
+
+	lease := config.Lease
+	if lease <= 0 {
+		lease = defaultLease
+	}
+
+	key := storageKey("slots", config)
+
+	acquired, err := rl.storage.AcquireSlot(ctx, key, leaseID, config.MaxRequests, lease)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to acquire slot: %w", err)
+	}
+
+	if !acquired {
+		return &domain.RateLimitStatus{Allowed: false, RemainingReqs: 0}, func() error { return nil }, nil
+	}
+
+	release := func() error {
+		return rl.storage.ReleaseSlot(context.WithoutCancel(ctx), key, leaseID)
+	}
+
+	return &domain.RateLimitStatus{Allowed: true}, release, nil
+}
+
+func (rl *RateLimiter) AcquireIP(ctx context.Context, ip string) (*domain.RateLimitStatus, ReleaseFunc, error) {
+	return rl.acquire(ctx, ip, domain.RateLimitTypeIP, rl.ipConcurrency)
+}
+
+func (rl *RateLimiter) AcquireToken(ctx context.Context, token string) (*domain.RateLimitStatus, ReleaseFunc, error) {
+	return rl.acquire(ctx, token, domain.RateLimitTypeToken, rl.tokenConcurrency)
+}
+
+func (rl *RateLimiter) acquire(ctx context.Context, key string, limitType domain.RateLimitType, limit int) (*domain.RateLimitStatus, ReleaseFunc, error) {
+	if limit <= 0 {
+		return &domain.RateLimitStatus{Allowed: true}, func() error { return nil }, nil
+	}
+
+	config := domain.RateLimitConfig{
+		Key:         key,
+		Type:        limitType,
+		Strategy:    domain.StrategyConcurrency,
+		MaxRequests: limit,
+		Lease:       rl.lease,
+	}
+	return rl.AcquireSlot(ctx, config)
+}
+
+func newLeaseID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
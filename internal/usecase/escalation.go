@@ -0,0 +1,52 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
+)
+
+const defaultOffenseMemory = 24 * time.Hour
+
+// SetBlockEscalation replaces the block duration with durations, one per
+// successive block of the same IP or token, e.g. 1m, 5m, 30m and 24h.
+// Offenses are forgotten once a key goes memory past the end of its last
+// block without being blocked again, one day when zero.
+func (rl *RateLimiter) SetBlockEscalation(durations []time.Duration, memory time.Duration) {
+	rl.escalation = domain.Escalation{Durations: durations, Memory: memory}
+}
+
+func (rl *RateLimiter) checkEscalated(ctx context.Context, config domain.RateLimitConfig) (*domain.RateLimitStatus, error) {
+	offenseKey := storageKey("offenses", config)
+
+	offenses, err := rl.storage.Get(ctx, offenseKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get offenses: %w", err)
+	}
+
+	durations := config.Escalation.Durations
+	step := int(offenses)
+	if step >= len(durations) {
+		step = len(durations) - 1
+	}
+	config.BlockDuration = durations[step]
+
+	status, err := rl.check(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if status.Tripped {
+		memory := config.Escalation.Memory
+		if memory <= 0 {
+			memory = defaultOffenseMemory
+		}
+		if _, err := rl.storage.RecordOffense(ctx, offenseKey, config.BlockDuration+memory); err != nil {
+			return nil, fmt.Errorf("failed to record offense: %w", err)
+		}
+	}
+
+	return status, nil
+}
@@ -0,0 +1,209 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
+)
+
+// globalKey is the key shared by every request under the global limit.
+const globalKey = "all"
+
+// SetTokenOrganization makes token share the limit of org with the other
+// tokens of the organization.
+func (rl *RateLimiter) SetTokenOrganization(token, org string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.tokenOrgs[token] = org
+}
+
+// SetOrganizationLimit caps the requests of all the tokens of org together.
+func (rl *RateLimiter) SetOrganizationLimit(org string, limit domain.Limit) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.orgLimits[org] = limit
+}
+
+// SetGlobalLimit sets a service-wide ceiling checked after the IP and token
+// limits. A zero limit disables it.
+func (rl *RateLimiter) SetGlobalLimit(limit domain.Limit) {
+	rl.globalLimit = limit
+}
+
+// OrganizationConfig returns the limit shared by the tokens of org. It
+// denies until the window resets instead of blocking the organization.
+func (rl *RateLimiter) OrganizationConfig(org string) domain.RateLimitConfig {
+	rl.mu.RLock()
+	limit := rl.orgLimits[org]
+	rl.mu.RUnlock()
+
+	return domain.RateLimitConfig{
+		Key:         org,
+		Type:        domain.RateLimitTypeOrganization,
+		Strategy:    domain.StrategyFixedWindow,
+		MaxRequests: limit.MaxRequests,
+		Window:      limit.Window,
+	}
+}
+
+// GlobalConfig returns the service-wide limit.
+func (rl *RateLimiter) GlobalConfig() domain.RateLimitConfig {
+	return domain.RateLimitConfig{
+		Key:         globalKey,
+		Type:        domain.RateLimitTypeGlobal,
+		Strategy:    domain.StrategyFixedWindow,
+		MaxRequests: rl.globalLimit.MaxRequests,
+		Window:      rl.globalLimit.Window,
+	}
+}
+
+// Chain returns config followed by the enclosing levels configured for it:
+// the organization of a token, then the global limit.
+func (rl *RateLimiter) Chain(config domain.RateLimitConfig) []domain.RateLimitConfig {
+	return append([]domain.RateLimitConfig{config}, rl.Enclosing(config.Type, config.Key)...)
+}
+
+// Enclosing returns the levels enclosing the IP or token key, without the
+// key's own limit.
+func (rl *RateLimiter) Enclosing(limitType domain.RateLimitType, key string) []domain.RateLimitConfig {
+	var levels []domain.RateLimitConfig
+
+	if limitType == domain.RateLimitTypeToken {
+		rl.mu.RLock()
+		org, exists := rl.tokenOrgs[key]
+		_, limited := rl.orgLimits[org]
+		rl.mu.RUnlock()
+
+		if exists && limited {
+			levels = append(levels, rl.OrganizationConfig(org))
+		}
+	}
+
+	if rl.globalLimit.MaxRequests > 0 {
+		levels = append(levels, rl.GlobalConfig())
+	}
+
+	return levels
+}
+
+// CheckChain accepts the request only if every level allows it. Fixed
+// window levels are first read without being counted, and the first one
+// that would deny is checked alone, so a denied request consumes nothing
+// and only trips the block of the denying level. Levels are then checked
+// one at a time, so each storage call only touches the keys of one level.
+// At most one level may use a strategy other than fixed window, which
+// cannot be read without being counted; it is checked first. Should a
+// fixed window level still deny, because of a concurrent request, the
+// units taken by the fixed window levels before it are refunded.
+//
+// The returned status is the one of the denying level or, when allowed, of
+// the level with the fewest remaining requests, with Level set to its type.
+func (rl *RateLimiter) CheckChain(ctx context.Context, levels []domain.RateLimitConfig) (*domain.RateLimitStatus, error) {
+	var ordered, fixed []domain.RateLimitConfig
+	for _, level := range levels {
+		if level.Strategy == "" || level.Strategy == domain.StrategyFixedWindow {
+			fixed = append(fixed, level)
+		} else {
+			ordered = append(ordered, level)
+		}
+	}
+	if len(ordered) > 1 {
+		return nil, fmt.Errorf("at most one level of a chain may use a strategy other than %q", domain.StrategyFixedWindow)
+	}
+
+	if len(levels) > 1 {
+		for _, level := range fixed {
+			denies, err := rl.wouldDeny(ctx, level)
+			if err != nil {
+				return nil, err
+			}
+			if !denies {
+				continue
+			}
+
+			status, err := rl.CheckLimit(ctx, level)
+			if err != nil {
+				return nil, err
+			}
+			status.Level = level.Type
+			if !status.Allowed {
+				return status, nil
+			}
+			// The window reset in between, so the request counts as usual.
+			if err := rl.refund(ctx, []domain.RateLimitConfig{level}); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	ordered = append(ordered, fixed...)
+
+	var tightest *domain.RateLimitStatus
+	for i, level := range ordered {
+		status, err := rl.CheckLimit(ctx, level)
+		if err != nil {
+			if refundErr := rl.refund(ctx, ordered[:i]); refundErr != nil {
+				return nil, fmt.Errorf("%w (%v)", err, refundErr)
+			}
+			return nil, err
+		}
+		status.Level = level.Type
+
+		if !status.Allowed {
+			if err := rl.refund(ctx, ordered[:i]); err != nil {
+				return nil, err
+			}
+			return status, nil
+		}
+
+		if tightest == nil || status.RemainingReqs < tightest.RemainingReqs {
+			tightest = status
+		}
+	}
+
+	return tightest, nil
+}
+
+// wouldDeny reports whether the fixed window level is blocked or lacks room
+// for the request, without counting it.
+func (rl *RateLimiter) wouldDeny(ctx context.Context, level domain.RateLimitConfig) (bool, error) {
+	blocked, err := rl.storage.IsBlocked(ctx, storageKey("block", level))
+	if err != nil {
+		return false, fmt.Errorf("failed to check block status: %w", err)
+	}
+	if blocked {
+		return true, nil
+	}
+
+	counters, err := windowCounters(level)
+	if err != nil {
+		return false, err
+	}
+	for _, counter := range counters {
+		count, err := rl.storage.Get(ctx, counter.Key)
+		if err != nil {
+			return false, fmt.Errorf("failed to get counter: %w", err)
+		}
+		if int(count)+cost(level) > counter.MaxRequests {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// refund returns the units taken by the fixed window levels. The other
+// strategies cannot be charged back, so their levels keep the request.
+func (rl *RateLimiter) refund(ctx context.Context, levels []domain.RateLimitConfig) error {
+	for _, level := range levels {
+		if level.Strategy != "" && level.Strategy != domain.StrategyFixedWindow {
+			continue
+		}
+		if err := rl.Charge(ctx, level, -cost(level)); err != nil {
+			return fmt.Errorf("failed to refund %s limit: %w", level.Type, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
+)
+
+// policyResyncInterval bounds how long a change missed by the watch, e.g.
+// during a reconnection, takes to be applied.
+const policyResyncInterval = time.Minute
+
+// policyRetryInterval is how often a failed watch or resync is retried. It
+// is a variable so tests can shorten it.
+var policyRetryInterval = 5 * time.Second
+
+// SyncPolicies applies the token policies of store and keeps applying its
+// changes until ctx is done. Policies from store take precedence over the
+// ones set locally, which are restored when removed from store. It returns
+// the error of the first attempt, if any, but keeps retrying in the
+// background, so a brief store outage at startup only delays the sync.
+func (rl *RateLimiter) SyncPolicies(ctx context.Context, store domain.PolicyStore) error {
+	sync := &policySync{
+		limiter: rl,
+		store:   store,
+		local:   rl.TokenPolicies(),
+		synced:  make(map[string]bool),
+	}
+
+	changes, watchErr := store.Watch(ctx)
+	syncErr := sync.resync(ctx)
+
+	go sync.run(ctx, changes, syncErr != nil)
+	return errors.Join(watchErr, syncErr)
+}
+
+type policySync struct {
+	limiter *RateLimiter
+	store   domain.PolicyStore
+	local   map[string]domain.TokenPolicy
+	// synced holds the tokens whose policy comes from store.
+	synced map[string]bool
+}
+
+// run applies the changes received on changes, a nil channel when the
+// watch failed, and resyncs every policyResyncInterval. stale reports that
+// the last resync or change failed, so a resync is retried sooner.
+func (s *policySync) run(ctx context.Context, changes <-chan string, stale bool) {
+	resync := time.NewTicker(policyResyncInterval)
+	defer resync.Stop()
+
+	retry := time.NewTicker(policyRetryInterval)
+	defer retry.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case token, ok := <-changes:
+			if !ok {
+				// The watch ended, e.g. the connection dropped: subscribe
+				// again on the next retry.
+				changes = nil
+				continue
+			}
+			if err := s.apply(ctx, token); err != nil {
+				log.Printf("Failed to apply shared policy: %v", err)
+				stale = true
+			}
+		case <-resync.C:
+			stale = true
+		case <-retry.C:
+		}
+
+		if changes == nil {
+			watched, err := s.store.Watch(ctx)
+			if err != nil {
+				log.Printf("Failed to watch shared policies: %v", err)
+			} else {
+				// Changes made while unsubscribed were missed.
+				changes, stale = watched, true
+			}
+		}
+		if stale {
+			if err := s.resync(ctx); err != nil {
+				log.Printf("Failed to resync shared policies: %v", err)
+			} else {
+				stale = false
+			}
+		}
+	}
+}
+
+func (s *policySync) apply(ctx context.Context, token string) error {
+	policy, exists, err := s.store.Policy(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		s.set(token, policy)
+	} else {
+		s.restore(token)
+	}
+	return nil
+}
+
+func (s *policySync) resync(ctx context.Context) error {
+	policies, err := s.store.Policies(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load shared policies: %w", err)
+	}
+
+	for token := range s.synced {
+		if _, exists := policies[token]; !exists {
+			s.restore(token)
+		}
+	}
+	for token, policy := range policies {
+		s.set(token, policy)
+	}
+	return nil
+}
+
+func (s *policySync) set(token string, policy domain.TokenPolicy) {
+	s.limiter.SetTokenPolicy(token, policy)
+	s.synced[token] = true
+}
+
+func (s *policySync) restore(token string) {
+	delete(s.synced, token)
+
+	if policy, exists := s.local[token]; exists {
+		s.limiter.SetTokenPolicy(token, policy)
+	} else {
+		s.limiter.RemoveTokenPolicy(token)
+	}
+}
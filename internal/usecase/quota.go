@@ -0,0 +1,66 @@
+package usecase
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
+)
+
+// SetQuotas sets the calendar quotas applied to every IP and token.
+func (rl *RateLimiter) SetQuotas(ipQuotas, tokenQuotas []domain.Quota) {
+	rl.ipQuotas = ipQuotas
+	rl.tokenQuotas = tokenQuotas
+}
+
+// SetTokenQuotas replaces the default token quotas for token, e.g. to apply
+// a customer's plan in their own timezone.
+func (rl *RateLimiter) SetTokenQuotas(token string, quotas []domain.Quota) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.quotasByToken[token] = quotas
+}
+
+// quotaCounter returns the counter of the period quota is in at now. The
+// period start is part of the key, so a new period always starts from zero,
+// and the counter expires when the period ends. Exhausting a quota denies
+// until the period ends without blocking the key or counting an offense.
+func quotaCounter(config domain.RateLimitConfig, quota domain.Quota, now time.Time) (domain.WindowCounter, error) {
+	start, end, err := periodBounds(quota, now)
+	if err != nil {
+		return domain.WindowCounter{}, err
+	}
+
+	return domain.WindowCounter{
+		Key:         fmt.Sprintf("%s:%s:%d", storageKey("quota", config), quota.Period, start.Unix()),
+		MaxRequests: quota.MaxRequests,
+		Window:      end.Sub(now).Truncate(time.Millisecond) + time.Millisecond,
+		NoBlock:     true,
+	}, nil
+}
+
+func periodBounds(quota domain.Quota, now time.Time) (time.Time, time.Time, error) {
+	location := quota.Location
+	if location == nil {
+		location = time.UTC
+	}
+
+	local := now.In(location)
+	year, month, day := local.Date()
+
+	switch quota.Period {
+	case domain.PeriodHour:
+		start := time.Date(year, month, day, local.Hour(), 0, 0, 0, location)
+		return start, start.Add(time.Hour), nil
+	case domain.PeriodDay:
+		return time.Date(year, month, day, 0, 0, 0, 0, location), time.Date(year, month, day+1, 0, 0, 0, 0, location), nil
+	case domain.PeriodWeek:
+		day -= (int(local.Weekday()) + 6) % 7
+		return time.Date(year, month, day, 0, 0, 0, 0, location), time.Date(year, month, day+7, 0, 0, 0, 0, location), nil
+	case domain.PeriodMonth:
+		return time.Date(year, month, 1, 0, 0, 0, 0, location), time.Date(year, month+1, 1, 0, 0, 0, 0, location), nil
+	default:
+		return time.Time{}, time.Time{}, fmt.Errorf("unknown quota period %q", quota.Period)
+	}
+}
@@ -3,17 +3,41 @@ package usecase
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/eduardohermesneto/rate-limiter/internal/domain"
 )
 
+// RateLimiter checks requests against the configured limits. The per-token
+// and per-organization settings may be changed while requests are being
+// checked; the other setters are meant to be called before serving.
 type RateLimiter struct {
 	storage       domain.Storage
 	ipLimit       int
 	tokenLimit    int
 	blockDuration time.Duration
-	tokenLimits   map[string]int
+	strategy      domain.Strategy
+	burst         int
+	ipWindow      time.Duration
+	tokenWindow   time.Duration
+	ipWindows     []domain.Limit
+	tokenWindows  []domain.Limit
+	escalation    domain.Escalation
+	ipQuotas      []domain.Quota
+	tokenQuotas   []domain.Quota
+	globalLimit   domain.Limit
+
+	// mu guards the registries below.
+	mu            sync.RWMutex
+	tokenPolicies map[string]domain.TokenPolicy
+	quotasByToken map[string][]domain.Quota
+	tokenOrgs     map[string]string
+	orgLimits     map[string]domain.Limit
+
+	ipConcurrency    int
+	tokenConcurrency int
+	lease            time.Duration
 }
 
 func NewRateLimiter(storage domain.Storage, ipLimit, tokenLimit int, blockDuration time.Duration) *RateLimiter {
@@ -22,87 +46,219 @@ func NewRateLimiter(storage domain.Storage, ipLimit, tokenLimit int, blockDurati
 		ipLimit:       ipLimit,
 		tokenLimit:    tokenLimit,
 		blockDuration: blockDuration,
-		tokenLimits:   make(map[string]int),
+		tokenPolicies: make(map[string]domain.TokenPolicy),
+		quotasByToken: make(map[string][]domain.Quota),
+		tokenOrgs:     make(map[string]string),
+		orgLimits:     make(map[string]domain.Limit),
 	}
 }
 
+// SetTokenLimit gives token its own request limit, keeping the default
+// window, strategy and block duration.
 func (rl *RateLimiter) SetTokenLimit(token string, limit int) {
-	rl.tokenLimits[token] = limit
+	rl.SetTokenPolicy(token, domain.TokenPolicy{MaxRequests: limit, BlockDuration: rl.blockDuration})
 }
 
-func (rl *RateLimiter) CheckLimit(ctx context.Context, config domain.RateLimitConfig) (*domain.RateLimitStatus, error) {
-	blockKey := fmt.Sprintf("block:%s:%s", config.Type, config.Key)
+// SetTokenPolicy replaces the default limits of token with policy.
+func (rl *RateLimiter) SetTokenPolicy(token string, policy domain.TokenPolicy) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
 
-	blocked, err := rl.storage.IsBlocked(ctx, blockKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to check block status: %w", err)
+	rl.tokenPolicies[token] = policy
+}
+
+// RemoveTokenPolicy makes token use the default limits again.
+func (rl *RateLimiter) RemoveTokenPolicy(token string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	delete(rl.tokenPolicies, token)
+}
+
+// TokenPolicies returns a copy of the policies set per token.
+func (rl *RateLimiter) TokenPolicies() map[string]domain.TokenPolicy {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	policies := make(map[string]domain.TokenPolicy, len(rl.tokenPolicies))
+	for token, policy := range rl.tokenPolicies {
+		policies[token] = policy
 	}
+	return policies
+}
 
-	if blocked {
-		ttl, err := rl.storage.GetTTL(ctx, blockKey)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get TTL: %w", err)
-		}
+// SetStrategy selects the algorithm used by CheckIP and CheckToken. burst is
+// only used by the token bucket strategy; zero means the request limit.
+func (rl *RateLimiter) SetStrategy(strategy domain.Strategy, burst int) {
+	rl.strategy = strategy
+	rl.burst = burst
+}
 
-		return &domain.RateLimitStatus{
-			Allowed:       false,
-			RemainingReqs: 0,
-			BlockedUntil:  time.Now().Add(ttl),
-		}, nil
+// SetWindows sets the period the IP and token limits apply to. Zero keeps
+// the default of one second.
+func (rl *RateLimiter) SetWindows(ipWindow, tokenWindow time.Duration) {
+	rl.ipWindow = ipWindow
+	rl.tokenWindow = tokenWindow
+}
+
+// SetWindowLimits evaluates several windows per IP and per token instead of
+// a single limit. Tokens with a custom limit keep it.
+func (rl *RateLimiter) SetWindowLimits(ipLimits, tokenLimits []domain.Limit) {
+	rl.ipWindows = ipLimits
+	rl.tokenWindows = tokenLimits
+}
+
+func (rl *RateLimiter) CheckLimit(ctx context.Context, config domain.RateLimitConfig) (*domain.RateLimitStatus, error) {
+	if len(config.Limits) > 1 && config.Strategy != "" && config.Strategy != domain.StrategyFixedWindow {
+		return nil, fmt.Errorf("strategy %q does not support multiple windows", config.Strategy)
+	}
+	if len(config.Quotas) > 0 && config.Strategy != "" && config.Strategy != domain.StrategyFixedWindow {
+		return nil, fmt.Errorf("strategy %q does not support quotas", config.Strategy)
 	}
 
-	countKey := fmt.Sprintf("count:%s:%s", config.Type, config.Key)
+	if len(config.Escalation.Durations) > 0 {
+		return rl.checkEscalated(ctx, config)
+	}
+	return rl.check(ctx, config)
+}
 
-	count, err := rl.storage.Increment(ctx, countKey, time.Second)
+func (rl *RateLimiter) check(ctx context.Context, config domain.RateLimitConfig) (*domain.RateLimitStatus, error) {
+	var (
+		status *domain.RateLimitStatus
+		err    error
+	)
+	switch config.Strategy {
+	case "", domain.StrategyFixedWindow:
+		status, err = rl.checkFixedWindow(ctx, config)
+	case domain.StrategyTokenBucket:
+		status, err = rl.checkTokenBucket(ctx, config)
+	case domain.StrategySlidingLog, domain.StrategySlidingWindow:
+		status, err = rl.checkSlidingWindow(ctx, config)
+	case domain.StrategyGCRA:
+		status, err = rl.checkGCRA(ctx, config)
+	case domain.StrategyConcurrency:
+		return nil, fmt.Errorf("strategy %q must be enforced with AcquireSlot", config.Strategy)
+	default:
+		return nil, fmt.Errorf("unknown rate limit strategy %q", config.Strategy)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to increment counter: %w", err)
+		return nil, err
 	}
 
-	if int(count) > config.MaxRequests {
-		if err := rl.storage.SetBlock(ctx, blockKey, config.BlockDuration); err != nil {
-			return nil, fmt.Errorf("failed to set block: %w", err)
-		}
-
-		return &domain.RateLimitStatus{
-			Allowed:       false,
-			RemainingReqs: 0,
-			BlockedUntil:  time.Now().Add(config.BlockDuration),
-		}, nil
+	// Only fixed window counters report which window they come from.
+	if status.Limit == 0 {
+		status.Limit = tightestLimit(config)
 	}
+	return status, nil
+}
 
-	remaining := config.MaxRequests - int(count)
-	if remaining < 0 {
-		remaining = 0
+// tightestLimit returns the smallest request limit of config.
+func tightestLimit(config domain.RateLimitConfig) int {
+	if len(config.Limits) == 0 {
+		return config.MaxRequests
 	}
 
-	return &domain.RateLimitStatus{
-		Allowed:       true,
-		RemainingReqs: remaining,
-		BlockedUntil:  time.Time{},
-	}, nil
+	tightest := config.Limits[0].MaxRequests
+	for _, limit := range config.Limits[1:] {
+		tightest = min(tightest, limit.MaxRequests)
+	}
+	return tightest
 }
 
 func (rl *RateLimiter) CheckIP(ctx context.Context, ip string) (*domain.RateLimitStatus, error) {
-	config := domain.RateLimitConfig{
+	return rl.CheckChain(ctx, rl.Chain(rl.IPConfig(ip)))
+}
+
+func (rl *RateLimiter) CheckToken(ctx context.Context, token string) (*domain.RateLimitStatus, error) {
+	return rl.CheckChain(ctx, rl.Chain(rl.TokenConfig(token)))
+}
+
+// IPConfig returns the limits applied to ip by CheckIP.
+func (rl *RateLimiter) IPConfig(ip string) domain.RateLimitConfig {
+	return domain.RateLimitConfig{
 		Key:           ip,
 		Type:          domain.RateLimitTypeIP,
+		Strategy:      rl.strategy,
 		MaxRequests:   rl.ipLimit,
+		Window:        rl.ipWindow,
 		BlockDuration: rl.blockDuration,
+		Burst:         rl.burst,
+		Escalation:    rl.escalation,
+		Limits:        rl.ipWindows,
+		Quotas:        rl.ipQuotas,
 	}
-	return rl.CheckLimit(ctx, config)
 }
 
-func (rl *RateLimiter) CheckToken(ctx context.Context, token string) (*domain.RateLimitStatus, error) {
-	limit := rl.tokenLimit
-	if customLimit, exists := rl.tokenLimits[token]; exists {
-		limit = customLimit
+// TokenConfig returns the limits applied to token by CheckToken. Tokens
+// with a policy do not use the default windows, nor the default quotas
+// when the policy uses a strategy other than fixed window.
+func (rl *RateLimiter) TokenConfig(token string) domain.RateLimitConfig {
+	rl.mu.RLock()
+	policy, hasPolicy := rl.tokenPolicies[token]
+	customQuotas, hasQuotas := rl.quotasByToken[token]
+	rl.mu.RUnlock()
+
+	quotas := rl.tokenQuotas
+	if hasQuotas {
+		quotas = customQuotas
 	}
 
 	config := domain.RateLimitConfig{
 		Key:           token,
 		Type:          domain.RateLimitTypeToken,
-		MaxRequests:   limit,
+		Strategy:      rl.strategy,
+		MaxRequests:   rl.tokenLimit,
+		Window:        rl.tokenWindow,
 		BlockDuration: rl.blockDuration,
+		Burst:         rl.burst,
+		Escalation:    rl.escalation,
+		Limits:        rl.tokenWindows,
+		Quotas:        quotas,
+	}
+
+	if !hasPolicy {
+		return config
+	}
+
+	config.MaxRequests = policy.MaxRequests
+	config.BlockDuration = policy.BlockDuration
+	config.Burst = policy.Burst
+	config.Limits = nil
+	if policy.Window > 0 {
+		config.Window = policy.Window
+	}
+	if policy.Strategy != "" {
+		config.Strategy = policy.Strategy
+	}
+	// Only the fixed window strategy supports quotas, so a policy switching
+	// strategy drops the default ones instead of failing every request.
+	if !hasQuotas && config.Strategy != "" && config.Strategy != domain.StrategyFixedWindow {
+		config.Quotas = nil
+	}
+	if policy.BlockDuration <= 0 {
+		config.Escalation = domain.Escalation{}
+	}
+
+	return config
+}
+
+// Charge adds units to the counters of config after the request was
+// accepted, e.g. once the response size is known. Only the fixed window
+// strategy supports it.
+func (rl *RateLimiter) Charge(ctx context.Context, config domain.RateLimitConfig, units int) error {
+	if config.Strategy != "" && config.Strategy != domain.StrategyFixedWindow {
+		return fmt.Errorf("%s: %w", config.Strategy, domain.ErrStrategyNotSupported)
+	}
+
+	counters, err := windowCounters(config)
+	if err != nil {
+		return err
+	}
+
+	for _, counter := range counters {
+		if _, err := rl.storage.IncrementBy(ctx, counter.Key, int64(units), counter.Window); err != nil {
+			return fmt.Errorf("failed to charge counter: %w", err)
+		}
 	}
-	return rl.CheckLimit(ctx, config)
+	return nil
 }
@@ -2,9 +2,13 @@ package usecase
 
 import (
 	"context"
+	"errors"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
 	"github.com/eduardohermesneto/rate-limiter/internal/infra/storage"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -122,3 +126,870 @@ func TestRateLimiter_DifferentIPs(t *testing.T) {
 	require.NoError(t, err)
 	assert.True(t, status.Allowed)
 }
+
+type sequentialStorage struct {
+	domain.Storage
+}
+
+func TestRateLimiter_NonAtomicStorage(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	limiter := NewRateLimiter(sequentialStorage{store}, 3, 10, 5*time.Second)
+
+	ctx := context.Background()
+	ip := "192.168.1.3"
+
+	for i := 0; i < 3; i++ {
+		status, err := limiter.CheckIP(ctx, ip)
+		require.NoError(t, err)
+		assert.True(t, status.Allowed)
+	}
+
+	status, err := limiter.CheckIP(ctx, ip)
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+}
+
+func TestRateLimiter_TokenBucket(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	limiter := NewRateLimiter(store, 5, 10, 5*time.Second)
+	limiter.SetStrategy(domain.StrategyTokenBucket, 8)
+
+	ctx := context.Background()
+	ip := "192.168.1.4"
+
+	for i := 0; i < 8; i++ {
+		status, err := limiter.CheckIP(ctx, ip)
+		require.NoError(t, err)
+		assert.True(t, status.Allowed)
+	}
+
+	status, err := limiter.CheckIP(ctx, ip)
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+	assert.True(t, status.BlockedUntil.Before(time.Now().Add(time.Second)))
+
+	time.Sleep(250 * time.Millisecond)
+
+	status, err = limiter.CheckIP(ctx, ip)
+	require.NoError(t, err)
+	assert.True(t, status.Allowed)
+}
+
+func TestRateLimiter_StrategyNotSupported(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	limiter := NewRateLimiter(sequentialStorage{store}, 5, 10, 5*time.Second)
+	limiter.SetStrategy(domain.StrategyTokenBucket, 0)
+
+	_, err := limiter.CheckIP(context.Background(), "192.168.1.5")
+	assert.ErrorIs(t, err, domain.ErrStrategyNotSupported)
+}
+
+func TestRateLimiter_SlidingStrategies(t *testing.T) {
+	for _, strategy := range []domain.Strategy{domain.StrategySlidingLog, domain.StrategySlidingWindow} {
+		t.Run(string(strategy), func(t *testing.T) {
+			store := storage.NewMemoryStorage()
+			defer store.Close()
+
+			limiter := NewRateLimiter(store, 4, 10, 5*time.Second)
+			limiter.SetStrategy(strategy, 0)
+
+			ctx := context.Background()
+			ip := "192.168.1.6"
+
+			for i := 0; i < 4; i++ {
+				status, err := limiter.CheckIP(ctx, ip)
+				require.NoError(t, err)
+				assert.True(t, status.Allowed)
+			}
+
+			status, err := limiter.CheckIP(ctx, ip)
+			require.NoError(t, err)
+			assert.False(t, status.Allowed)
+		})
+	}
+}
+
+func TestRateLimiter_GCRA(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	limiter := NewRateLimiter(store, 10, 10, 5*time.Second)
+	limiter.SetStrategy(domain.StrategyGCRA, 2)
+
+	ctx := context.Background()
+	ip := "192.168.1.7"
+
+	for i := 0; i < 2; i++ {
+		status, err := limiter.CheckIP(ctx, ip)
+		require.NoError(t, err)
+		assert.True(t, status.Allowed)
+	}
+
+	status, err := limiter.CheckIP(ctx, ip)
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+	assert.WithinDuration(t, time.Now().Add(100*time.Millisecond), status.BlockedUntil, 20*time.Millisecond)
+}
+
+func TestRateLimiter_MultipleWindows(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	limiter := NewRateLimiter(store, 5, 10, 5*time.Second)
+
+	ctx := context.Background()
+	config := domain.RateLimitConfig{
+		Key:           "192.168.1.8",
+		Type:          domain.RateLimitTypeIP,
+		BlockDuration: time.Second,
+		Limits: []domain.Limit{
+			{MaxRequests: 10, Window: time.Second},
+			{MaxRequests: 4, Window: time.Minute},
+		},
+	}
+
+	for i := 0; i < 4; i++ {
+		status, err := limiter.CheckLimit(ctx, config)
+		require.NoError(t, err)
+		assert.True(t, status.Allowed)
+		assert.Equal(t, 3-i, status.RemainingReqs)
+	}
+
+	status, err := limiter.CheckLimit(ctx, config)
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+	assert.Equal(t, time.Minute, status.Window)
+
+	config.Strategy = domain.StrategyTokenBucket
+	_, err = limiter.CheckLimit(ctx, config)
+	assert.Error(t, err)
+}
+
+func TestRateLimiter_ConfigurableWindow(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	limiter := NewRateLimiter(store, 3, 10, time.Second)
+	limiter.SetWindows(3*time.Hour, time.Minute)
+
+	ctx := context.Background()
+	ip := "192.168.1.9"
+
+	for i := 0; i < 3; i++ {
+		status, err := limiter.CheckIP(ctx, ip)
+		require.NoError(t, err)
+		assert.True(t, status.Allowed)
+		assert.Equal(t, 3*time.Hour, status.Window)
+		assert.WithinDuration(t, time.Now().Add(3*time.Hour), status.ResetAt, time.Second)
+	}
+
+	status, err := limiter.CheckIP(ctx, ip)
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+
+	time.Sleep(1100 * time.Millisecond)
+
+	status, err = limiter.CheckIP(ctx, ip)
+	require.NoError(t, err)
+	assert.False(t, status.Allowed, "window of 3h must not reset after the block expires")
+}
+
+func TestRateLimiter_WindowAppliesToGCRA(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	limiter := NewRateLimiter(store, 2, 10, time.Second)
+	limiter.SetStrategy(domain.StrategyGCRA, 1)
+	limiter.SetWindows(time.Hour, time.Second)
+
+	ctx := context.Background()
+	ip := "192.168.1.10"
+
+	status, err := limiter.CheckIP(ctx, ip)
+	require.NoError(t, err)
+	assert.True(t, status.Allowed)
+
+	status, err = limiter.CheckIP(ctx, ip)
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+	assert.WithinDuration(t, time.Now().Add(30*time.Minute), status.BlockedUntil, time.Second)
+}
+
+func TestRateLimiter_ConcurrencyLimit(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	limiter := NewRateLimiter(store, 5, 10, 5*time.Second)
+	limiter.SetConcurrencyLimits(2, 0, time.Minute)
+
+	ctx := context.Background()
+	ip := "192.168.1.11"
+
+	status, releaseFirst, err := limiter.AcquireIP(ctx, ip)
+	require.NoError(t, err)
+	assert.True(t, status.Allowed)
+
+	status, _, err = limiter.AcquireIP(ctx, ip)
+	require.NoError(t, err)
+	assert.True(t, status.Allowed)
+
+	status, _, err = limiter.AcquireIP(ctx, ip)
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+
+	require.NoError(t, releaseFirst())
+
+	status, _, err = limiter.AcquireIP(ctx, ip)
+	require.NoError(t, err)
+	assert.True(t, status.Allowed)
+
+	status, _, err = limiter.AcquireToken(ctx, "any-token")
+	require.NoError(t, err)
+	assert.True(t, status.Allowed, "token concurrency is disabled")
+}
+
+func TestRateLimiter_WeightedCost(t *testing.T) {
+	strategies := []domain.Strategy{
+		domain.StrategyFixedWindow,
+		domain.StrategyTokenBucket,
+		domain.StrategySlidingLog,
+		domain.StrategySlidingWindow,
+		domain.StrategyGCRA,
+	}
+
+	for _, strategy := range strategies {
+		t.Run(string(strategy), func(t *testing.T) {
+			store := storage.NewMemoryStorage()
+			defer store.Close()
+
+			limiter := NewRateLimiter(store, 10, 10, 5*time.Second)
+			limiter.SetStrategy(strategy, 0)
+			limiter.SetWindows(time.Minute, time.Minute)
+
+			ctx := context.Background()
+			config := limiter.IPConfig("192.168.1.12")
+			config.Cost = 4
+
+			for i := 0; i < 2; i++ {
+				status, err := limiter.CheckLimit(ctx, config)
+				require.NoError(t, err)
+				assert.True(t, status.Allowed)
+			}
+
+			status, err := limiter.CheckLimit(ctx, config)
+			require.NoError(t, err)
+			assert.False(t, status.Allowed)
+		})
+	}
+}
+
+func TestRateLimiter_Charge(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	limiter := NewRateLimiter(store, 10, 10, 5*time.Second)
+
+	ctx := context.Background()
+	config := limiter.IPConfig("192.168.1.13")
+
+	status, err := limiter.CheckLimit(ctx, config)
+	require.NoError(t, err)
+	assert.True(t, status.Allowed)
+
+	require.NoError(t, limiter.Charge(ctx, config, 9))
+
+	status, err = limiter.CheckLimit(ctx, config)
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+
+	config.Strategy = domain.StrategyGCRA
+	assert.ErrorIs(t, limiter.Charge(ctx, config, 1), domain.ErrStrategyNotSupported)
+}
+
+func TestRateLimiter_Quota(t *testing.T) {
+	saoPaulo, err := time.LoadLocation("America/Sao_Paulo")
+	require.NoError(t, err)
+
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	limiter := NewRateLimiter(store, 5, 100, 0)
+	limiter.SetWindows(time.Minute, time.Minute)
+	limiter.SetTokenQuotas("paid-token", []domain.Quota{{MaxRequests: 3, Period: domain.PeriodDay, Location: saoPaulo}})
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		status, err := limiter.CheckToken(ctx, "paid-token")
+		require.NoError(t, err)
+		assert.True(t, status.Allowed)
+	}
+
+	status, err := limiter.CheckToken(ctx, "paid-token")
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+
+	_, midnight, err := periodBounds(domain.Quota{Period: domain.PeriodDay, Location: saoPaulo}, time.Now())
+	require.NoError(t, err)
+	assert.WithinDuration(t, midnight, status.ResetAt, 10*time.Millisecond)
+
+	status, err = limiter.CheckToken(ctx, "other-token")
+	require.NoError(t, err)
+	assert.True(t, status.Allowed)
+}
+
+func TestRateLimiter_QuotaDoesNotBlock(t *testing.T) {
+	for name, store := range map[string]domain.Storage{
+		"atomic":     storage.NewMemoryStorage(),
+		"sequential": sequentialStorage{storage.NewMemoryStorage()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer store.Close()
+
+			limiter := NewRateLimiter(store, 5, 100, time.Minute)
+			limiter.SetWindows(time.Minute, time.Minute)
+			limiter.SetBlockEscalation([]time.Duration{time.Minute, time.Hour}, time.Hour)
+			limiter.SetTokenQuotas("paid-token", []domain.Quota{{MaxRequests: 2, Period: domain.PeriodMonth}})
+
+			ctx := context.Background()
+			for i := 0; i < 2; i++ {
+				status, err := limiter.CheckToken(ctx, "paid-token")
+				require.NoError(t, err)
+				assert.True(t, status.Allowed)
+			}
+
+			_, nextMonth, err := periodBounds(domain.Quota{Period: domain.PeriodMonth}, time.Now())
+			require.NoError(t, err)
+
+			// An exhausted quota denies until the next period, without a
+			// block or an offense.
+			for i := 0; i < 2; i++ {
+				status, err := limiter.CheckToken(ctx, "paid-token")
+				require.NoError(t, err)
+				assert.False(t, status.Allowed)
+				assert.False(t, status.Tripped)
+				assert.WithinDuration(t, nextMonth, status.ResetAt, 10*time.Millisecond)
+				assert.WithinDuration(t, nextMonth, status.BlockedUntil, 10*time.Millisecond)
+			}
+
+			blocked, err := store.IsBlocked(ctx, "block:{token:paid-token}")
+			require.NoError(t, err)
+			assert.False(t, blocked)
+
+			offenses, err := store.Get(ctx, "offenses:{token:paid-token}")
+			require.NoError(t, err)
+			assert.Zero(t, offenses)
+		})
+	}
+}
+
+func TestRateLimiter_PolicyStrategyDropsDefaultQuotas(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	limiter := NewRateLimiter(store, 5, 100, 0)
+	limiter.SetQuotas(nil, []domain.Quota{{MaxRequests: 1000, Period: domain.PeriodDay}})
+	limiter.SetTokenPolicy("gcra-token", domain.TokenPolicy{MaxRequests: 2, Window: time.Minute, Strategy: domain.StrategyGCRA})
+	limiter.SetTokenLimit("fixed-token", 2)
+
+	assert.Empty(t, limiter.TokenConfig("gcra-token").Quotas)
+	assert.Len(t, limiter.TokenConfig("fixed-token").Quotas, 1)
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		status, err := limiter.CheckToken(ctx, "gcra-token")
+		require.NoError(t, err)
+		assert.True(t, status.Allowed)
+	}
+
+	status, err := limiter.CheckToken(ctx, "gcra-token")
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+}
+
+func TestPeriodBounds(t *testing.T) {
+	saoPaulo, err := time.LoadLocation("America/Sao_Paulo")
+	require.NoError(t, err)
+
+	now := time.Date(2024, time.February, 29, 1, 30, 0, 0, time.UTC) // Wednesday 22:30 in São Paulo
+
+	tests := []struct {
+		quota domain.Quota
+		start time.Time
+		end   time.Time
+	}{
+		{
+			quota: domain.Quota{Period: domain.PeriodHour},
+			start: time.Date(2024, time.February, 29, 1, 0, 0, 0, time.UTC),
+			end:   time.Date(2024, time.February, 29, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			quota: domain.Quota{Period: domain.PeriodDay},
+			start: time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC),
+			end:   time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			quota: domain.Quota{Period: domain.PeriodDay, Location: saoPaulo},
+			start: time.Date(2024, time.February, 28, 0, 0, 0, 0, saoPaulo),
+			end:   time.Date(2024, time.February, 29, 0, 0, 0, 0, saoPaulo),
+		},
+		{
+			quota: domain.Quota{Period: domain.PeriodWeek, Location: saoPaulo},
+			start: time.Date(2024, time.February, 26, 0, 0, 0, 0, saoPaulo),
+			end:   time.Date(2024, time.March, 4, 0, 0, 0, 0, saoPaulo),
+		},
+		{
+			quota: domain.Quota{Period: domain.PeriodMonth},
+			start: time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC),
+			end:   time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		start, end, err := periodBounds(tt.quota, now)
+		require.NoError(t, err)
+		assert.True(t, tt.start.Equal(start), "%s start: got %s", tt.quota.Period, start)
+		assert.True(t, tt.end.Equal(end), "%s end: got %s", tt.quota.Period, end)
+	}
+
+	_, _, err = periodBounds(domain.Quota{Period: "year"}, now)
+	assert.Error(t, err)
+}
+
+func TestRateLimiter_OrganizationLimit(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	limiter := NewRateLimiter(store, 5, 3, 5*time.Second)
+	limiter.SetWindows(time.Minute, time.Minute)
+	limiter.SetOrganizationLimit("acme", domain.Limit{MaxRequests: 4, Window: time.Minute})
+	limiter.SetTokenOrganization("token-a", "acme")
+	limiter.SetTokenOrganization("token-b", "acme")
+
+	ctx := context.Background()
+	for _, token := range []string{"token-a", "token-a", "token-b", "token-b"} {
+		status, err := limiter.CheckToken(ctx, token)
+		require.NoError(t, err)
+		assert.True(t, status.Allowed)
+	}
+
+	status, err := limiter.CheckToken(ctx, "token-b")
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+	assert.Equal(t, domain.RateLimitTypeOrganization, status.Level)
+
+	// The denied request was refunded, so token-b still has one request
+	// left at its own level.
+	count, err := store.Get(ctx, "count:{token:token-b}")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	status, err = limiter.CheckToken(ctx, "token-c")
+	require.NoError(t, err)
+	assert.True(t, status.Allowed)
+}
+
+func TestRateLimiter_GlobalLimit(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	limiter := NewRateLimiter(store, 5, 10, 5*time.Second)
+	limiter.SetWindows(time.Minute, time.Minute)
+	limiter.SetGlobalLimit(domain.Limit{MaxRequests: 3, Window: time.Minute})
+
+	ctx := context.Background()
+	status, err := limiter.CheckIP(ctx, "192.168.1.20")
+	require.NoError(t, err)
+	assert.True(t, status.Allowed)
+	assert.Equal(t, 2, status.RemainingReqs)
+	assert.Equal(t, domain.RateLimitTypeGlobal, status.Level)
+
+	for _, ip := range []string{"192.168.1.21", "192.168.1.22"} {
+		status, err := limiter.CheckIP(ctx, ip)
+		require.NoError(t, err)
+		assert.True(t, status.Allowed)
+	}
+
+	status, err = limiter.CheckToken(ctx, "any-token")
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+	assert.Equal(t, domain.RateLimitTypeGlobal, status.Level)
+
+	// The global level denies without blocking, so the IP is not blocked
+	// at its own level either.
+	blocked, err := store.IsBlocked(ctx, "block:{ip:192.168.1.20}")
+	require.NoError(t, err)
+	assert.False(t, blocked)
+}
+
+// peakStorage records the highest value each counter reached.
+type peakStorage struct {
+	domain.Storage
+	peaks map[string]int64
+}
+
+func (p peakStorage) IncrementBy(ctx context.Context, key string, value int64, expiration time.Duration) (int64, error) {
+	count, err := p.Storage.IncrementBy(ctx, key, value, expiration)
+	if count > p.peaks[key] {
+		p.peaks[key] = count
+	}
+	return count, err
+}
+
+func TestRateLimiter_ChainDenialConsumesNothing(t *testing.T) {
+	store := peakStorage{Storage: storage.NewMemoryStorage(), peaks: make(map[string]int64)}
+	defer store.Close()
+
+	limiter := NewRateLimiter(store, 5, 3, 5*time.Second)
+	limiter.SetWindows(time.Minute, time.Minute)
+	limiter.SetGlobalLimit(domain.Limit{MaxRequests: 2, Window: time.Minute})
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		status, err := limiter.CheckToken(ctx, "token-a")
+		require.NoError(t, err)
+		assert.True(t, status.Allowed)
+	}
+
+	// token-a sits one request below its own limit while the global
+	// ceiling denies: the token counter must not move in between, or a
+	// concurrent request of token-a would exceed it and be blocked.
+	for i := 0; i < 3; i++ {
+		status, err := limiter.CheckToken(ctx, "token-a")
+		require.NoError(t, err)
+		assert.False(t, status.Allowed)
+		assert.Equal(t, domain.RateLimitTypeGlobal, status.Level)
+
+		assert.Equal(t, int64(2), store.peaks["count:{token:token-a}"])
+	}
+
+	blocked, err := store.IsBlocked(ctx, "block:{token:token-a}")
+	require.NoError(t, err)
+	assert.False(t, blocked)
+}
+
+// staleStorage reads every counter as zero, as a replica lagging behind
+// concurrent requests would.
+type staleStorage struct {
+	*storage.MemoryStorage
+}
+
+func (staleStorage) Get(ctx context.Context, key string) (int64, error) {
+	return 0, nil
+}
+
+func TestRateLimiter_ChainDenialSkipsRefundOfOtherStrategies(t *testing.T) {
+	store := staleStorage{storage.NewMemoryStorage()}
+	defer store.Close()
+
+	limiter := NewRateLimiter(store, 5, 10, 5*time.Second)
+	limiter.SetTokenPolicy("bucket-token", domain.TokenPolicy{
+		MaxRequests: 10,
+		Window:      time.Minute,
+		Strategy:    domain.StrategyTokenBucket,
+	})
+	limiter.SetTokenOrganization("bucket-token", "acme")
+	limiter.SetOrganizationLimit("acme", domain.Limit{MaxRequests: 1, Window: time.Minute})
+
+	ctx := context.Background()
+	status, err := limiter.CheckToken(ctx, "bucket-token")
+	require.NoError(t, err)
+	assert.True(t, status.Allowed)
+
+	// The stale read lets the organization level through the peek, so it
+	// only denies after the token bucket took its token.
+	status, err = limiter.CheckToken(ctx, "bucket-token")
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+	assert.Equal(t, domain.RateLimitTypeOrganization, status.Level)
+}
+
+func TestRateLimiter_ChainStrategies(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	limiter := NewRateLimiter(store, 5, 10, 5*time.Second)
+	limiter.SetStrategy(domain.StrategyTokenBucket, 0)
+	limiter.SetGlobalLimit(domain.Limit{MaxRequests: 100, Window: time.Minute})
+
+	ctx := context.Background()
+	status, err := limiter.CheckIP(ctx, "192.168.1.23")
+	require.NoError(t, err)
+	assert.True(t, status.Allowed)
+
+	levels := []domain.RateLimitConfig{limiter.IPConfig("192.168.1.23"), limiter.TokenConfig("abc")}
+	_, err = limiter.CheckChain(ctx, levels)
+	assert.Error(t, err)
+}
+
+func TestRateLimiter_BlockEscalation(t *testing.T) {
+	for name, store := range map[string]domain.Storage{
+		"atomic":     storage.NewMemoryStorage(),
+		"sequential": sequentialStorage{storage.NewMemoryStorage()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer store.Close()
+
+			limiter := NewRateLimiter(store, 1, 10, time.Hour)
+			limiter.SetWindows(time.Minute, time.Minute)
+			limiter.SetBlockEscalation([]time.Duration{50 * time.Millisecond, 200 * time.Millisecond}, time.Minute)
+
+			ctx := context.Background()
+			ip := "192.168.1.30"
+
+			status, err := limiter.CheckIP(ctx, ip)
+			require.NoError(t, err)
+			assert.True(t, status.Allowed)
+
+			for _, expected := range []time.Duration{50 * time.Millisecond, 200 * time.Millisecond, 200 * time.Millisecond} {
+				status, err = limiter.CheckIP(ctx, ip)
+				require.NoError(t, err)
+				assert.False(t, status.Allowed)
+				assert.True(t, status.Tripped)
+				assert.WithinDuration(t, time.Now().Add(expected), status.BlockedUntil, 20*time.Millisecond)
+
+				status, err = limiter.CheckIP(ctx, ip)
+				require.NoError(t, err)
+				assert.False(t, status.Allowed)
+				assert.False(t, status.Tripped)
+
+				time.Sleep(expected + 10*time.Millisecond)
+			}
+
+			offenses, err := store.Get(ctx, "offenses:{ip:"+ip+"}")
+			require.NoError(t, err)
+			assert.Equal(t, int64(3), offenses)
+		})
+	}
+}
+
+func TestRateLimiter_TokenPolicy(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	limiter := NewRateLimiter(store, 5, 2, time.Hour)
+	limiter.SetTokenPolicy("premium-token", domain.TokenPolicy{
+		MaxRequests: 3,
+		Window:      100 * time.Millisecond,
+	})
+	limiter.SetTokenPolicy("bucket-token", domain.TokenPolicy{
+		MaxRequests:   1,
+		Window:        time.Minute,
+		BlockDuration: time.Minute,
+		Strategy:      domain.StrategyTokenBucket,
+		Burst:         4,
+	})
+
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		status, err := limiter.CheckToken(ctx, "premium-token")
+		require.NoError(t, err)
+		assert.True(t, status.Allowed)
+	}
+
+	status, err := limiter.CheckToken(ctx, "premium-token")
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+	assert.False(t, status.Tripped)
+
+	time.Sleep(110 * time.Millisecond)
+
+	status, err = limiter.CheckToken(ctx, "premium-token")
+	require.NoError(t, err)
+	assert.True(t, status.Allowed)
+
+	for i := 0; i < 4; i++ {
+		status, err := limiter.CheckToken(ctx, "bucket-token")
+		require.NoError(t, err)
+		assert.True(t, status.Allowed)
+	}
+
+	status, err = limiter.CheckToken(ctx, "bucket-token")
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+
+	for i := 0; i < 3; i++ {
+		status, err = limiter.CheckToken(ctx, "anonymous-token")
+		require.NoError(t, err)
+	}
+	assert.False(t, status.Allowed)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), status.BlockedUntil, time.Second)
+}
+
+func TestRateLimiter_RemoveTokenPolicy(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	limiter := NewRateLimiter(store, 5, 1, time.Hour)
+	limiter.SetTokenLimit("premium-token", 10)
+	limiter.SetTokenLimit("other-token", 20)
+
+	policies := limiter.TokenPolicies()
+	assert.Len(t, policies, 2)
+	assert.Equal(t, 10, policies["premium-token"].MaxRequests)
+
+	delete(policies, "other-token")
+	assert.Len(t, limiter.TokenPolicies(), 2)
+
+	limiter.RemoveTokenPolicy("premium-token")
+	assert.NotContains(t, limiter.TokenPolicies(), "premium-token")
+
+	ctx := context.Background()
+
+	status, err := limiter.CheckToken(ctx, "premium-token")
+	require.NoError(t, err)
+	assert.True(t, status.Allowed)
+
+	status, err = limiter.CheckToken(ctx, "premium-token")
+	require.NoError(t, err)
+	assert.False(t, status.Allowed)
+}
+
+func TestRateLimiter_ConcurrentPolicyUpdates(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	limiter := NewRateLimiter(store, 5, 1000, time.Hour)
+	limiter.SetOrganizationLimit("acme", domain.Limit{MaxRequests: 1000, Window: time.Minute})
+
+	ctx := context.Background()
+	tokens := []string{"token-a", "token-b", "token-c"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				token := tokens[(i+j)%len(tokens)]
+				limiter.SetTokenLimit(token, 100+j)
+				limiter.SetTokenQuotas(token, []domain.Quota{{MaxRequests: 1000, Period: domain.PeriodDay}})
+				limiter.SetTokenOrganization(token, "acme")
+				limiter.TokenPolicies()
+				limiter.RemoveTokenPolicy(token)
+			}
+		}(i)
+
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, err := limiter.CheckToken(ctx, tokens[(i+j)%len(tokens)])
+				assert.NoError(t, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestRateLimiter_SyncPolicies(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	policies := storage.NewMemoryPolicyStore()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	require.NoError(t, policies.SetPolicy(ctx, "shared-token", domain.TokenPolicy{MaxRequests: 50}))
+
+	limiter := NewRateLimiter(store, 5, 1, time.Hour)
+	limiter.SetTokenLimit("local-token", 10)
+	require.NoError(t, limiter.SyncPolicies(ctx, policies))
+
+	assert.Equal(t, 50, limiter.TokenConfig("shared-token").MaxRequests)
+
+	require.NoError(t, policies.SetPolicy(ctx, "local-token", domain.TokenPolicy{MaxRequests: 20}))
+	assert.Eventually(t, func() bool {
+		return limiter.TokenConfig("local-token").MaxRequests == 20
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, policies.RemovePolicy(ctx, "local-token"))
+	require.NoError(t, policies.RemovePolicy(ctx, "shared-token"))
+	assert.Eventually(t, func() bool {
+		return limiter.TokenConfig("local-token").MaxRequests == 10 &&
+			limiter.TokenConfig("shared-token").MaxRequests == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+// flakyPolicyStore fails its first calls to Watch and Policies.
+type flakyPolicyStore struct {
+	domain.PolicyStore
+	mu       sync.Mutex
+	failures int
+}
+
+func (f *flakyPolicyStore) fail() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func (f *flakyPolicyStore) Watch(ctx context.Context) (<-chan string, error) {
+	if err := f.fail(); err != nil {
+		return nil, err
+	}
+	return f.PolicyStore.Watch(ctx)
+}
+
+func (f *flakyPolicyStore) Policies(ctx context.Context) (map[string]domain.TokenPolicy, error) {
+	if err := f.fail(); err != nil {
+		return nil, err
+	}
+	return f.PolicyStore.Policies(ctx)
+}
+
+func TestRateLimiter_SyncPoliciesRetries(t *testing.T) {
+	retryInterval := policyRetryInterval
+	policyRetryInterval = 20 * time.Millisecond
+	defer func() { policyRetryInterval = retryInterval }()
+
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	policies := &flakyPolicyStore{PolicyStore: storage.NewMemoryPolicyStore(), failures: 3}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	require.NoError(t, policies.SetPolicy(ctx, "shared-token", domain.TokenPolicy{MaxRequests: 50}))
+
+	limiter := NewRateLimiter(store, 5, 1, time.Hour)
+	assert.Error(t, limiter.SyncPolicies(ctx, policies))
+	assert.Equal(t, 1, limiter.TokenConfig("shared-token").MaxRequests)
+
+	assert.Eventually(t, func() bool {
+		return limiter.TokenConfig("shared-token").MaxRequests == 50
+	}, time.Second, 10*time.Millisecond)
+
+	// The watch was subscribed again, so changes keep being applied.
+	require.NoError(t, policies.SetPolicy(ctx, "shared-token", domain.TokenPolicy{MaxRequests: 60}))
+	assert.Eventually(t, func() bool {
+		return limiter.TokenConfig("shared-token").MaxRequests == 60
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestStorageKey_HashTag(t *testing.T) {
+	config := domain.RateLimitConfig{Key: "abc}123", Type: domain.RateLimitTypeToken}
+
+	quota, err := quotaCounter(config, domain.Quota{MaxRequests: 1, Period: domain.PeriodDay}, time.Now())
+	require.NoError(t, err)
+
+	keys := []string{storageKey("block", config), storageKey("count", config), storageKey("log", config), quota.Key}
+	for _, key := range keys {
+		start := strings.Index(key, "{")
+		end := strings.Index(key[start:], "}")
+		assert.Equal(t, "token:abc", key[start+1:start+end], key)
+	}
+}
@@ -0,0 +1,24 @@
+package usecase
+
+import "github.com/eduardohermesneto/rate-limiter/internal/domain"
+
+// RuleConfig returns the limits rule applies to the IP or token key. Each
+// rule has its own counters, separate from the default limits and from the
+// other rules.
+func (rl *RateLimiter) RuleConfig(rule domain.Rule, limitType domain.RateLimitType, key string) domain.RateLimitConfig {
+	config := domain.RateLimitConfig{
+		Key:           rule.Name + ":" + key,
+		Type:          limitType,
+		Strategy:      rule.Strategy,
+		MaxRequests:   rule.MaxRequests,
+		Window:        rule.Window,
+		BlockDuration: rule.BlockDuration,
+		Burst:         rule.Burst,
+		Limits:        rule.Limits,
+	}
+	if rule.BlockDuration > 0 {
+		config.Escalation = rl.escalation
+	}
+
+	return config
+}
@@ -0,0 +1,219 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eduardohermesneto/rate-limiter/internal/domain"
+)
+
+func (rl *RateLimiter) checkFixedWindow(ctx context.Context, config domain.RateLimitConfig) (*domain.RateLimitStatus, error) {
+	blockKey := storageKey("block", config)
+	counters, err := windowCounters(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if atomic, ok := rl.storage.(domain.AtomicStorage); ok {
+		status, err := atomic.CheckAndBlock(ctx, blockKey, counters, cost(config), config.BlockDuration)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check limit: %w", err)
+		}
+		return status, nil
+	}
+
+	blocked, err := rl.storage.IsBlocked(ctx, blockKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check block status: %w", err)
+	}
+
+	if blocked {
+		ttl, err := rl.storage.GetTTL(ctx, blockKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get TTL: %w", err)
+		}
+
+		return &domain.RateLimitStatus{
+			Allowed:       false,
+			RemainingReqs: 0,
+			BlockedUntil:  time.Now().Add(ttl),
+			ResetAt:       time.Now().Add(ttl),
+		}, nil
+	}
+
+	status := &domain.RateLimitStatus{Allowed: true, RemainingReqs: -1}
+	for _, counter := range counters {
+		count, err := rl.storage.IncrementBy(ctx, counter.Key, int64(cost(config)), counter.Window)
+		if err != nil {
+			return nil, fmt.Errorf("failed to increment counter: %w", err)
+		}
+
+		ttl, err := rl.storage.GetTTL(ctx, counter.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get TTL: %w", err)
+		}
+		resetAt := time.Now().Add(ttl)
+
+		if int(count) > counter.MaxRequests {
+			tripped := config.BlockDuration > 0 && !counter.NoBlock
+			blockedUntil := resetAt
+			if tripped {
+				if err := rl.storage.SetBlock(ctx, blockKey, config.BlockDuration); err != nil {
+					return nil, fmt.Errorf("failed to set block: %w", err)
+				}
+				blockedUntil = time.Now().Add(config.BlockDuration)
+			}
+
+			return &domain.RateLimitStatus{
+				Allowed:       false,
+				RemainingReqs: 0,
+				BlockedUntil:  blockedUntil,
+				Window:        counter.Window,
+				Limit:         counter.MaxRequests,
+				ResetAt:       resetAt,
+				Tripped:       tripped,
+			}, nil
+		}
+
+		remaining := counter.MaxRequests - int(count)
+		if status.RemainingReqs < 0 || remaining < status.RemainingReqs {
+			status.RemainingReqs = remaining
+			status.Window = counter.Window
+			status.Limit = counter.MaxRequests
+			status.ResetAt = resetAt
+		}
+	}
+
+	return status, nil
+}
+
+// windowCounters returns one counter per configured window and quota. A
+// config without Limits keeps a single MaxRequests per Window counter.
+func windowCounters(config domain.RateLimitConfig) ([]domain.WindowCounter, error) {
+	counters := make([]domain.WindowCounter, 0, len(config.Limits)+len(config.Quotas)+1)
+	if len(config.Limits) == 0 {
+		counters = append(counters, domain.WindowCounter{
+			Key:         storageKey("count", config),
+			MaxRequests: config.MaxRequests,
+			Window:      window(config),
+		})
+	}
+
+	for _, limit := range config.Limits {
+		counters = append(counters, domain.WindowCounter{
+			Key:         fmt.Sprintf("%s:%s", storageKey("count", config), limit.Window),
+			MaxRequests: limit.MaxRequests,
+			Window:      limit.Window,
+		})
+	}
+
+	now := time.Now()
+	for _, quota := range config.Quotas {
+		counter, err := quotaCounter(config, quota, now)
+		if err != nil {
+			return nil, err
+		}
+		counters = append(counters, counter)
+	}
+
+	return counters, nil
+}
+
+func (rl *RateLimiter) checkTokenBucket(ctx context.Context, config domain.RateLimitConfig) (*domain.RateLimitStatus, error) {
+	buckets, ok := rl.storage.(domain.TokenBucketStorage)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", config.Strategy, domain.ErrStrategyNotSupported)
+	}
+
+	capacity := config.Burst
+	if capacity <= 0 {
+		capacity = config.MaxRequests
+	}
+
+	refillRate := config.RefillRate
+	if refillRate <= 0 {
+		refillRate = float64(config.MaxRequests) / window(config).Seconds()
+	}
+
+	if capacity <= 0 || refillRate <= 0 {
+		return &domain.RateLimitStatus{Allowed: false, RemainingReqs: 0}, nil
+	}
+
+	status, err := buckets.TakeToken(ctx, storageKey("bucket", config), capacity, refillRate, cost(config))
+	if err != nil {
+		return nil, fmt.Errorf("failed to take token: %w", err)
+	}
+
+	return status, nil
+}
+
+func (rl *RateLimiter) checkSlidingWindow(ctx context.Context, config domain.RateLimitConfig) (*domain.RateLimitStatus, error) {
+	windows, ok := rl.storage.(domain.SlidingWindowStorage)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", config.Strategy, domain.ErrStrategyNotSupported)
+	}
+
+	blockKey := storageKey("block", config)
+
+	var (
+		status *domain.RateLimitStatus
+		err    error
+	)
+	if config.Strategy == domain.StrategySlidingLog {
+		status, err = windows.SlidingLog(ctx, blockKey, storageKey("log", config), config.MaxRequests, cost(config), window(config), config.BlockDuration)
+	} else {
+		status, err = windows.SlidingWindowCounter(ctx, blockKey, storageKey("window", config), config.MaxRequests, cost(config), window(config), config.BlockDuration)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to check sliding window: %w", err)
+	}
+
+	return status, nil
+}
+
+func (rl *RateLimiter) checkGCRA(ctx context.Context, config domain.RateLimitConfig) (*domain.RateLimitStatus, error) {
+	cells, ok := rl.storage.(domain.GCRAStorage)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", config.Strategy, domain.ErrStrategyNotSupported)
+	}
+
+	if config.MaxRequests <= 0 {
+		return &domain.RateLimitStatus{Allowed: false, RemainingReqs: 0}, nil
+	}
+
+	burst := config.Burst
+	if burst <= 0 {
+		burst = config.MaxRequests
+	}
+
+	emissionInterval := window(config) / time.Duration(config.MaxRequests)
+	tolerance := emissionInterval * time.Duration(burst)
+
+	status, err := cells.GCRA(ctx, storageKey("gcra", config), emissionInterval, tolerance, cost(config))
+	if err != nil {
+		return nil, fmt.Errorf("failed to check gcra: %w", err)
+	}
+
+	return status, nil
+}
+
+func cost(config domain.RateLimitConfig) int {
+	if config.Cost <= 0 {
+		return 1
+	}
+	return config.Cost
+}
+
+func window(config domain.RateLimitConfig) time.Duration {
+	if config.Window <= 0 {
+		return time.Second
+	}
+	return config.Window
+}
+
+// storageKey hash-tags the type and key, so in a Redis Cluster every key of
+// a client lands on the same slot and can be used by one script.
+func storageKey(prefix string, config domain.RateLimitConfig) string {
+	return fmt.Sprintf("%s:{%s:%s}", prefix, config.Type, config.Key)
+}